@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+
+	"fprof/report/annotate"
+)
+
+func annotateCommand(args []string) {
+	fs := flag.NewFlagSet("annotate", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr,
+			"Usage: %s annotate [-o <dir>] [-file <source> | -func <name>] <file.json>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	var pReportDir = fs.String("o", defaultReportDir, "Directory to write the annotated <source>.txt files to")
+	var pFile = fs.String("file", "", "Print the given source file to stdout instead")
+	var pFunction = fs.String("func", "", "Print the given function to stdout instead")
+	var pVerbose = fs.Bool("v", false, "Be more verbose")
+	addInputFlags(fs)
+	fs.Parse(args)
+
+	initLogger(*pVerbose)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	jsonfile := fs.Arg(0)
+	dir := *pReportDir
+	if dir == defaultReportDir {
+		if jsonfile == "-" {
+			/* Only the files written to a directory need one */
+			if *pFile == "" && *pFunction == "" {
+				fmt.Fprintln(os.Stderr, "-o is required when the profile is read from stdin")
+				fs.Usage()
+				os.Exit(2)
+			}
+		} else {
+			dir = jsonfile + ".d"
+		}
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	r := annotate.New(dir, w)
+	r.File = *pFile
+	r.Function = *pFunction
+	r.ReportFunctions(readProfile(jsonfile))
+}
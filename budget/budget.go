@@ -0,0 +1,258 @@
+/*
+Package budget checks a profile against performance budgets.
+
+Budgets are read from JSON, an array of objects naming exactly one of
+"function" (full name, e.g. "Obj.method"), "namespace" or "file" and any of
+the limits:
+
+	self_ms          time spent in the function(s) themselves
+	inclusive_ms     time including the functions called
+	calls            number of calls
+	self_share       self_ms as a fraction (0-1) of the profile duration
+	inclusive_share  inclusive_ms as a fraction of the profile duration
+
+For a namespace or a file the functions defined in it are summed up, so
+nested calls within the group count more than once towards inclusive_ms.
+
+A budget whose function, namespace or file is not in the profile fails, so
+that a misspelt name does not go unnoticed, unless it is marked "optional".
+
+	[
+		{ "function": "Db.query", "inclusive_ms": 250, "calls": 1000 },
+		{ "namespace": "Template", "self_share": 0.1 },
+		{ "file": "/srv/app/report.fe", "self_ms": 500 },
+		{ "function": "Cache.warm", "calls": 1, "optional": true }
+	]
+*/
+package budget
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+import fjson "fprof/json"
+
+type Budget struct {
+	Function  string `json:"function"`
+	NameSpace string `json:"namespace"`
+	File      string `json:"file"`
+	/* Skipped rather than failed when not in the profile */
+	Optional bool `json:"optional"`
+
+	SelfMs         *float64 `json:"self_ms"`
+	InclusiveMs    *float64 `json:"inclusive_ms"`
+	Calls          *float64 `json:"calls"`
+	SelfShare      *float64 `json:"self_share"`
+	InclusiveShare *float64 `json:"inclusive_share"`
+}
+
+func (b *Budget) Target() string {
+	switch {
+	case b.Function != "":
+		return "function " + b.Function
+	case b.NameSpace != "":
+		return "namespace " + b.NameSpace
+	}
+	return "file " + b.File
+}
+
+func (b *Budget) matches(f *fjson.FunctionProfile) bool {
+	switch {
+	case b.Function != "":
+		return f.FullName() == b.Function
+	case b.NameSpace != "":
+		return f.NameSpace == b.NameSpace
+	}
+	return f.Filename == b.File
+}
+
+func (b *Budget) validate() error {
+	targets := 0
+	for _, t := range []string{b.Function, b.NameSpace, b.File} {
+		if t != "" {
+			targets++
+		}
+	}
+	if targets != 1 {
+		return fmt.Errorf("budget must name one of function, namespace or file: %+v", *b)
+	}
+	if b.SelfMs == nil && b.InclusiveMs == nil && b.Calls == nil && b.SelfShare == nil && b.InclusiveShare == nil {
+		return fmt.Errorf("budget for %s sets no limit", b.Target())
+	}
+	return nil
+}
+
+func Load(r io.Reader) ([]*Budget, error) {
+	var budgets []*Budget
+	if err := json.NewDecoder(r).Decode(&budgets); err != nil {
+		return nil, err
+	}
+	for _, b := range budgets {
+		if err := b.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return budgets, nil
+}
+
+/* The outcome of checking one limit of a budget */
+type Result struct {
+	Budget *Budget
+	Limit  string
+	Max    float64
+	Actual float64
+	/* No function in the profile matched the budget */
+	Missing bool
+}
+
+func (r *Result) Exceeded() bool {
+	return !r.Missing && r.Actual > r.Max
+}
+
+/* Exceeded, or missing from the profile without being optional */
+func (r *Result) Failed() bool {
+	return r.Exceeded() || r.Missing && !r.Budget.Optional
+}
+
+func (r *Result) Name() string {
+	return r.Budget.Target() + " " + r.Limit
+}
+
+func (r *Result) String() string {
+	if r.Missing {
+		status := "FAIL"
+		if r.Budget.Optional {
+			status = "SKIP"
+		}
+		return fmt.Sprintf("%s %s: not found in the profile", status, r.Name())
+	}
+	status := "ok  "
+	relation := "<="
+	if r.Exceeded() {
+		status, relation = "FAIL", ">"
+	}
+	return fmt.Sprintf("%s %s: %.3f %s budget %.3f", status, r.Name(), r.Actual, relation, r.Max)
+}
+
+func Check(p *fjson.Profile, budgets []*Budget) []*Result {
+	functions := p.FileProfileMap.GetFunctionsSortedByExlusiveTime()
+	durationMs := p.Duration.InMilliseconds()
+	results := []*Result{}
+	for _, b := range budgets {
+		var self, inclusive fjson.TimeSpec
+		var calls fjson.Counter
+		found := false
+		for _, f := range functions {
+			if f == nil || !b.matches(f) {
+				continue
+			}
+			found = true
+			self.Add(f.OwnTime)
+			inclusive.Add(f.InclusiveDuration)
+			calls += f.Hits
+		}
+		share := func(ms float64) float64 {
+			if durationMs <= 0 {
+				return 0
+			}
+			return ms / durationMs
+		}
+		limits := []struct {
+			name   string
+			max    *float64
+			actual float64
+		}{
+			{"self_ms", b.SelfMs, self.InMilliseconds()},
+			{"inclusive_ms", b.InclusiveMs, inclusive.InMilliseconds()},
+			{"calls", b.Calls, float64(calls)},
+			{"self_share", b.SelfShare, share(self.InMilliseconds())},
+			{"inclusive_share", b.InclusiveShare, share(inclusive.InMilliseconds())},
+		}
+		for _, l := range limits {
+			if l.max == nil {
+				continue
+			}
+			results = append(results, &Result{b, l.name, *l.max, l.actual, !found})
+		}
+	}
+	return results
+}
+
+func Exceeded(results []*Result) []*Result {
+	exceeded := []*Result{}
+	for _, r := range results {
+		if r.Exceeded() {
+			exceeded = append(exceeded, r)
+		}
+	}
+	return exceeded
+}
+
+/* The results of budgets not in the profile and not optional */
+func Missing(results []*Result) []*Result {
+	missing := []*Result{}
+	for _, r := range results {
+		if r.Missing && !r.Budget.Optional {
+			missing = append(missing, r)
+		}
+	}
+	return missing
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+}
+
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+func WriteJUnit(w io.Writer, results []*Result) error {
+	suite := junitTestSuite{Name: "fprof budgets", Tests: len(results)}
+	for _, r := range results {
+		tc := junitTestCase{Name: r.Name(), ClassName: "fprof.budget"}
+		if r.Missing && r.Budget.Optional {
+			tc.Skipped = &junitSkipped{"not found in the profile"}
+			suite.Skipped++
+		} else if r.Missing {
+			tc.Failure = &junitFailure{"not found in the profile", "BudgetTargetMissing", r.String()}
+			suite.Failures++
+		} else if r.Exceeded() {
+			message := fmt.Sprintf("%s is %.3f, over the budget of %.3f", r.Limit, r.Actual, r.Max)
+			tc.Failure = &junitFailure{message, "BudgetExceeded", r.String()}
+			suite.Failures++
+		}
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suite); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,131 @@
+package budget
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+import fjson "fprof/json"
+
+var profileJson = []byte(`{
+	"duration": { "sec": 0, "nsec": 100000000 },
+	"files": {
+		"/a.fe": [
+			{
+				"functions": [
+					{
+						"name": "query",
+						"namespace": "Db",
+						"start_line": 1,
+						"exclusive_duration": { "sec": 0, "nsec": 0 },
+						"inclusive_duration": { "sec": 0, "nsec": 40000000 },
+						"hits": 10
+					},
+					{
+						"name": "connect",
+						"namespace": "Db",
+						"start_line": 1,
+						"exclusive_duration": { "sec": 0, "nsec": 0 },
+						"inclusive_duration": { "sec": 0, "nsec": 20000000 },
+						"hits": 1
+					}
+				],
+				"hits": 1,
+				"total_duration": { "sec": 0, "nsec": 1000 }
+			}
+		]
+	}
+}`)
+
+func check(t *testing.T, budgets string) []*Result {
+	b, err := Load(strings.NewReader(budgets))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Check(fjson.DecodeFromBytes(profileJson), b)
+}
+
+func TestCheck(t *testing.T) {
+	results := check(t, `[
+		{ "function": "Db.query", "inclusive_ms": 50, "calls": 5 },
+		{ "namespace": "Db", "self_ms": 50, "inclusive_share": 0.5 },
+		{ "file": "/nowhere.fe", "self_ms": 1 }
+	]`)
+	expected := []struct {
+		name     string
+		actual   float64
+		exceeded bool
+	}{
+		{"function Db.query inclusive_ms", 40, false},
+		{"function Db.query calls", 10, true},
+		{"namespace Db self_ms", 60, true},
+		{"namespace Db inclusive_share", 0.6, true},
+		{"file /nowhere.fe self_ms", 0, false},
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("Expected %d results, got %d", len(expected), len(results))
+	}
+	for i, e := range expected {
+		r := results[i]
+		if r.Name() != e.name || r.Actual != e.actual || r.Exceeded() != e.exceeded {
+			t.Errorf("%d: expected %+v, got %s", i, e, r)
+		}
+	}
+	if !results[4].Missing || !results[4].Failed() {
+		t.Errorf("Budget for a missing file must be reported as missing and fail")
+	}
+	if len(Exceeded(results)) != 3 || len(Missing(results)) != 1 {
+		t.Errorf("Expected 3 exceeded budgets and 1 missing")
+	}
+}
+
+func TestOptionalBudget(t *testing.T) {
+	results := check(t, `[{ "function": "Db.nowhere", "calls": 1, "optional": true }]`)
+	if len(results) != 1 || !results[0].Missing || results[0].Failed() || len(Missing(results)) != 0 {
+		t.Fatalf("An optional budget not in the profile must be skipped, got %v", results)
+	}
+	if s := results[0].String(); !strings.HasPrefix(s, "SKIP ") {
+		t.Errorf("Expected SKIP, got %s", s)
+	}
+}
+
+func TestLoadRejectsInvalidBudgets(t *testing.T) {
+	invalid := []string{
+		`[{ "self_ms": 1 }]`,
+		`[{ "function": "f", "file": "/a.fe", "self_ms": 1 }]`,
+		`[{ "function": "f" }]`,
+		`{}`,
+	}
+	for _, budgets := range invalid {
+		if _, err := Load(strings.NewReader(budgets)); err == nil {
+			t.Errorf("Expected an error for %s", budgets)
+		}
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	results := check(t, `[
+		{ "function": "Db.query", "calls": 5 },
+		{ "function": "Db.connect", "calls": 5 },
+		{ "function": "missing", "calls": 5, "optional": true },
+		{ "function": "Db.missing", "calls": 5 }
+	]`)
+	var b bytes.Buffer
+	if err := WriteJUnit(&b, results); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	expected := []string{
+		`<testsuite name="fprof budgets" tests="4" failures="2" skipped="1">`,
+		`<testcase name="function Db.query calls" classname="fprof.budget">`,
+		`<failure message="calls is 10.000, over the budget of 5.000" type="BudgetExceeded">`,
+		`<skipped message="not found in the profile"></skipped>`,
+		`<failure message="not found in the profile" type="BudgetTargetMissing">`,
+	}
+	for _, e := range expected {
+		if !strings.Contains(out, e) {
+			t.Errorf("Expected %s in:\n%s", e, out)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"fprof/budget"
+	"fprof/log"
+)
+
+/* Exit codes of check, apart from 1 for bad input and 2 for bad usage */
+const (
+	exitBudgetExceeded = 3
+	exitBudgetMissing  = 4
+)
+
+func checkCommand(args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr,
+			"Usage: %s check --budgets <budgets.json> [-junit <file.xml>] <file.json>\n"+
+				"Exits with %d when a budget is exceeded, else with %d when a budget names\n"+
+				"what is not in the profile and is not optional.\n", os.Args[0], exitBudgetExceeded, exitBudgetMissing)
+		fs.PrintDefaults()
+	}
+	var pBudgets = fs.String("budgets", "", "JSON file with the performance budgets")
+	var pJUnit = fs.String("junit", "", "Also write the results as JUnit XML to the given file")
+	var pVerbose = fs.Bool("v", false, "Be more verbose, also list the budgets that are met")
+	addInputFlags(fs)
+	fs.Parse(args)
+
+	initLogger(*pVerbose)
+
+	if fs.NArg() != 1 || *pBudgets == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	in, err := os.Open(*pBudgets)
+	if err != nil {
+		log.Fatal(err)
+	}
+	budgets, err := budget.Load(in)
+	in.Close()
+	if err != nil {
+		log.Fatal(*pBudgets, ": ", err)
+	}
+
+	results := budget.Check(readProfile(fs.Arg(0)), budgets)
+	exceeded := budget.Exceeded(results)
+	missing := budget.Missing(results)
+	for _, r := range results {
+		if *pVerbose || r.Exceeded() || r.Missing {
+			fmt.Println(r)
+		}
+	}
+	fmt.Printf("%d of %d budget limits exceeded\n", len(exceeded), len(results))
+	if len(missing) > 0 {
+		fmt.Printf("%d budget limits name what is not in the profile\n", len(missing))
+	}
+
+	if *pJUnit != "" {
+		out := createOutput(*pJUnit)
+		err := budget.WriteJUnit(out, results)
+		out.Close()
+		if err != nil {
+			log.Fatal(*pJUnit, ": ", err)
+		}
+	}
+	switch {
+	case len(exceeded) > 0:
+		os.Exit(exitBudgetExceeded)
+	case len(missing) > 0:
+		os.Exit(exitBudgetMissing)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"fprof/log"
+	"fprof/report"
+	"fprof/report/csv"
+	"fprof/report/dot"
+	"fprof/report/editor"
+	"fprof/report/lcov"
+	"fprof/report/markdown"
+	"fprof/report/openmetrics"
+	"fprof/report/sarif"
+	"fprof/report/summary"
+)
+
+var exportFormats = "dot, csv, tsv, markdown, json, openmetrics, lcov, sarif, quickfix, linemetrics"
+
+func createOutput(file string) io.WriteCloser {
+	if file == "-" {
+		return os.Stdout
+	}
+	out, err := os.Create(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return out
+}
+
+func exportCommand(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr,
+			"Usage: %s export --format <format> [-o <file>] <file.json>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	var pFormat = fs.String("format", "", "Export format, one of: "+exportFormats)
+	var pOutput = fs.String("o", "-", "File to write the export to")
+	var pVerbose = fs.Bool("v", false, "Be more verbose")
+	var pTable = fs.String("table", csv.FunctionsTable, "csv, tsv: Table to export, functions or lines")
+	var pNodeFraction = fs.Float64("nodefraction", 0.005, "dot: Hide functions below <f>*duration inclusive time")
+	var pEdgeFraction = fs.Float64("edgefraction", 0.001, "dot: Hide calls below <f>*duration time")
+	var pBase = fs.String("base", "", "markdown: Add delta columns against this baseline profile")
+	var pFunctions = fs.Int("functions", 10, "markdown: Number of top functions")
+	var pLines = fs.Int("lines", 10, "markdown: Number of top lines")
+	var pWorst = fs.Int("worst", 10, "markdown: Number of worst severity items")
+	var pMaxLength = fs.Int("maxlength", 65536, "markdown: Leave out rows to stay within this many bytes, 0 for no limit")
+	var pTop = fs.Int("top", 50, "openmetrics: Number of functions to export, 0 for all")
+	var pSrcRoot = fs.String("srcroot", "", "sarif: Give the files below this directory relative to it")
+	addInputFlags(fs)
+	fs.Parse(args)
+
+	initLogger(*pVerbose)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	/*
+	 * The export is kept in memory until it is complete, so that neither an
+	 * unknown format nor a failing reporter leaves the -o file emptied or cut
+	 * short.
+	 */
+	w := &bytes.Buffer{}
+	var reporter report.Reporter
+	switch *pFormat {
+	case "dot":
+		r := dot.New(w)
+		r.NodeFraction = *pNodeFraction
+		r.EdgeFraction = *pEdgeFraction
+		reporter = r
+	case "csv", "tsv":
+		comma := ','
+		if *pFormat == "tsv" {
+			comma = '\t'
+		}
+		r := csv.New(w, comma)
+		r.Table = *pTable
+		reporter = r
+	case "markdown":
+		r := markdown.New(w)
+		r.Functions = *pFunctions
+		r.Lines = *pLines
+		r.Worst = *pWorst
+		r.MaxLength = *pMaxLength
+		if *pBase != "" {
+			r.Base = readProfile(*pBase)
+		}
+		reporter = r
+	case "json":
+		reporter = summary.New(w)
+	case "openmetrics":
+		r := openmetrics.New(w)
+		r.Top = *pTop
+		reporter = r
+	case "lcov":
+		reporter = lcov.New(w)
+	case "sarif":
+		r := sarif.New(w)
+		r.SrcRoot = *pSrcRoot
+		reporter = r
+	case "quickfix":
+		reporter = editor.NewQuickfix(w)
+	case "linemetrics":
+		reporter = editor.NewLineMetrics(w)
+	default:
+		log.Fatal("Unknown export format ", *pFormat, ", expecting one of: ", exportFormats)
+	}
+
+	reporter.ReportFunctions(readProfile(fs.Arg(0)))
+
+	out := createOutput(*pOutput)
+	if _, err := w.WriteTo(out); err != nil {
+		log.Fatal(*pOutput, ": ", err)
+	}
+	if err := out.Close(); err != nil {
+		log.Fatal(*pOutput, ": ", err)
+	}
+}
@@ -5,13 +5,10 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"path"
 	"path/filepath"
 
-	"fprof/json"
 	"fprof/log"
 	"fprof/osutil"
-	"fprof/report"
 	"fprof/report/html"
 )
 
@@ -20,6 +17,8 @@ var reportDir = defaultReportDir
 var runBrowser = true
 var browser = "google-chrome"
 var jsonfile = "-"
+var templatesDir = ""
+var singleFile = ""
 
 type SilentLogger struct{}
 
@@ -27,10 +26,36 @@ func (s *SilentLogger) Write(b []byte) (int, error) {
 	return 0, nil
 }
 
+var usages = []string{
+	"[-v] [-o <dir> | -single-file <report.html>] [-templates <dir>] [-w|-b <browser>] <file.json>",
+	"export --format <format> [-o <file>] <file.json>",
+	"top [-n <count>] [-sort <key>] [-filter <regexp>] [-color auto|always|never] <file.json>",
+	"annotate [-o <dir>] [-file <source> | -func <name>] <file.json>",
+	"check --budgets <budgets.json> [-junit <file.xml>] <file.json>",
+}
+
+/* Subcommands, selected by the first argument */
+var commands = map[string]func(args []string){
+	"annotate": annotateCommand,
+	"check":    checkCommand,
+	"export":   exportCommand,
+	"top":      topCommand,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
+		}
+	}
+
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr,
-			"Usage: %s [-v] [-o <dir>] [-w|-b <browser>] <file.json>\n", os.Args[0])
+		prefix := "Usage:"
+		for _, usage := range usages {
+			fmt.Fprintf(os.Stderr, "%s %s %s\n", prefix, os.Args[0], usage)
+			prefix = "      "
+		}
 		flag.PrintDefaults()
 	}
 
@@ -39,6 +64,9 @@ func main() {
 
 	var pReportDir = flag.String("o", reportDir, "Directory to generate profile reports")
 	var pVerbose = flag.Bool("v", false, "Be more verbose")
+	flag.StringVar(&singleFile, "single-file", singleFile, "Write the report as this one self-contained html file instead")
+	flag.StringVar(&templatesDir, "templates", templatesDir, "Directory of <name>.tmpl files overriding the report page templates")
+	addInputFlags(flag.CommandLine)
 	flag.Parse()
 
 	initLogger(*pVerbose)
@@ -64,7 +92,11 @@ func main() {
 	reportFromJson()
 
 	if runBrowser {
-		openInBrowser(reportDir + "/functions.html")
+		if singleFile != "" {
+			openInBrowser(singleFile)
+		} else {
+			openInBrowser(reportDir + "/functions.html")
+		}
 	}
 }
 
@@ -92,47 +124,12 @@ func openInBrowser(htmlfile string) {
 }
 
 func reportFromJson() {
-	in := os.Stdin
-	if jsonfile != "-" {
-		var err error
-		in, err = os.Open(jsonfile)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-	profile, err := json.From(in)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	html.New(reportDir).ReportFunctions(profile)
-}
-
-func generateMetricFiles(profileFor report.LineMetricForFiles) {
-	lastLine := 0
-	lineMetricGenerator := func(file io.Writer, metrics []report.LineMetric) func(int, string) {
-		return func(line int, text string) {
-			metric := metrics[line-1]
-			/* The metric width must match the width set by
-			 * ferite_profile.c write_profile_line_entry()
-			 */
-			fmt.Fprintf(file, "%62v %v\n", metric, text)
-			lastLine = line
-		}
-	}
-
-	filePrefix := reportDir + "/" + report.FilesDir
-	for filename, lineMetrics := range profileFor {
-		profileFilename := filePrefix + filename
-		osutil.CreateDir(path.Dir(profileFilename))
-		file, err := os.Create(profileFilename)
-		if err != nil {
-			log.Fatal(profileFilename, ":", err)
-		}
-		defer file.Close()
-
-		printer := lineMetricGenerator(file, lineMetrics)
-		osutil.ForEachLineInFile(filename, printer)
-		printer(lastLine+1, "")
+	var r *html.HtmlReporter
+	if singleFile != "" {
+		r = html.NewSingleFile(singleFile)
+	} else {
+		r = html.New(reportDir)
 	}
+	r.Templates = templatesDir
+	r.ReportFunctions(readProfile(jsonfile))
 }
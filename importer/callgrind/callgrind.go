@@ -0,0 +1,380 @@
+/*
+Package callgrind reads callgrind profiles, as written by valgrind's
+callgrind tool or PHP's Xdebug profiler, into a json.Profile so that they
+get the same reports as ferite profiles.
+
+Callgrind records costs rather than hits, so a line counts one hit for each
+cost record naming it and a function as many hits as the calls made to it.
+Costs of the chosen event are converted to time using the unit in the event
+name, e.g. "Time_(10ns)"; events without a known unit, like valgrind's
+instruction counts, are taken as nanoseconds.
+*/
+package callgrind
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+import "fprof/json"
+
+var unitsInNs = map[string]float64{
+	"ns":   1,
+	"10ns": 10,
+	"us":   1000,
+	"ms":   1000000,
+	"s":    1000000000,
+}
+
+type functionKey struct {
+	file string
+	name string
+}
+
+type function struct {
+	profile   *json.FunctionProfile
+	self      int64
+	children  int64
+	firstLine int
+	callers   map[callerKey]*json.FunctionCaller
+}
+
+type callerKey struct {
+	name string
+	file string
+	at   int
+}
+
+type call struct {
+	count  uint64
+	callee functionKey
+}
+
+type parser struct {
+	/* Options */
+	event     string
+	nsPerUnit float64
+
+	events     []string
+	eventIndex int
+	positions  int
+	summary    int64
+	total      int64
+
+	/* Name compression, files and functions have separate tables */
+	files     map[string]string
+	functions map[string]string
+
+	file       string
+	subFile    string
+	fn         *function
+	calleeFile string
+	calleeName string
+	lastPos    []int64
+	pending    *call
+
+	lines map[string]map[int]*json.LineProfile
+	funcs map[functionKey]*function
+	order []*function
+}
+
+/*
+ * From reads a callgrind profile, taking the costs of the named event as
+ * duration. An empty event selects the first event of the profile.
+ */
+func From(stream io.Reader, event string) (*json.Profile, error) {
+	p := &parser{
+		event:     event,
+		positions: 1,
+		files:     make(map[string]string),
+		functions: make(map[string]string),
+		lines:     make(map[string]map[int]*json.LineProfile),
+		funcs:     make(map[functionKey]*function),
+	}
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		if err := p.parseLine(scanner.Text()); err != nil {
+			return nil, fmt.Errorf("callgrind line %d: %v", lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if p.events == nil {
+		return nil, errors.New("callgrind: no events: line found, not a callgrind profile")
+	}
+	return p.profile(), nil
+}
+
+/* Resolves "(id) name" and "(id)" compressed names */
+func decompress(table map[string]string, value string) string {
+	if !strings.HasPrefix(value, "(") {
+		return value
+	}
+	end := strings.Index(value, ")")
+	if end == -1 {
+		return value
+	}
+	id, name := value[:end+1], strings.TrimSpace(value[end+1:])
+	if name == "" {
+		return table[id]
+	}
+	table[id] = name
+	return name
+}
+
+func (p *parser) setEvents(value string) error {
+	p.events = strings.Fields(value)
+	p.eventIndex = 0
+	if p.event != "" {
+		p.eventIndex = -1
+		for i, e := range p.events {
+			if e == p.event || strings.HasPrefix(e, p.event+"_(") {
+				p.eventIndex = i
+			}
+		}
+		if p.eventIndex == -1 {
+			return fmt.Errorf("event %s not in the profile, have: %s", p.event, value)
+		}
+	}
+	p.nsPerUnit = 1
+	name := p.events[p.eventIndex]
+	if open := strings.LastIndex(name, "_("); open != -1 && strings.HasSuffix(name, ")") {
+		if ns, ok := unitsInNs[name[open+2:len(name)-1]]; ok {
+			p.nsPerUnit = ns
+		}
+	}
+	return nil
+}
+
+func (p *parser) parseLine(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return nil
+	}
+	if c := line[0]; c >= '0' && c <= '9' || c == '+' || c == '-' || c == '*' {
+		return p.parseCost(line)
+	}
+
+	eq := strings.IndexAny(line, "=:")
+	if eq == -1 {
+		return fmt.Errorf("unexpected line %q", line)
+	}
+	key, value := line[:eq], strings.TrimSpace(line[eq+1:])
+	switch key {
+	case "events":
+		return p.setEvents(value)
+	case "positions":
+		p.positions = len(strings.Fields(value))
+	case "summary", "totals":
+		costs := strings.Fields(value)
+		if p.eventIndex < len(costs) {
+			v, err := strconv.ParseInt(costs[p.eventIndex], 10, 64)
+			if err != nil {
+				return err
+			}
+			p.summary = v
+		}
+	case "fl":
+		p.file = decompress(p.files, value)
+		p.subFile = p.file
+	case "fi", "fe":
+		p.subFile = decompress(p.files, value)
+	case "fn":
+		p.fn = p.function(functionKey{p.file, decompress(p.functions, value)})
+		p.subFile = p.file
+	case "cfl", "cfi":
+		p.calleeFile = decompress(p.files, value)
+	case "cfn":
+		p.calleeName = decompress(p.functions, value)
+	case "calls":
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			return errors.New("calls= without count")
+		}
+		count, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return err
+		}
+		calleeFile := p.calleeFile
+		if calleeFile == "" {
+			calleeFile = p.file
+		}
+		p.pending = &call{count, functionKey{calleeFile, p.calleeName}}
+		p.calleeFile, p.calleeName = "", ""
+	}
+	/* Everything else (version, creator, cmd, ob, cob, jump, ...) is of no use here */
+	return nil
+}
+
+func (p *parser) function(key functionKey) *function {
+	f := p.funcs[key]
+	if f == nil {
+		fp := &json.FunctionProfile{}
+		name := key.name
+		/* "Class::method" becomes namespace Class and function method */
+		if sep := strings.LastIndex(name, "::"); sep > 0 {
+			fp.NameSpace, name = name[:sep], name[sep+2:]
+		}
+		fp.Name = name
+		fp.Filename = key.file
+		fp.IsNative = !path.IsAbs(key.file)
+		f = &function{profile: fp, callers: make(map[callerKey]*json.FunctionCaller)}
+		p.funcs[key] = f
+		p.order = append(p.order, f)
+	}
+	return f
+}
+
+/* Parses the positions of a cost line, returning the source line */
+func (p *parser) parsePositions(fields []string) (int, error) {
+	if len(fields) < p.positions {
+		return 0, errors.New("missing position")
+	}
+	if p.lastPos == nil {
+		p.lastPos = make([]int64, p.positions)
+	}
+	for i := 0; i < p.positions; i++ {
+		f := fields[i]
+		var v int64
+		var err error
+		switch {
+		case f == "*":
+			v = p.lastPos[i]
+		case f[0] == '+' || f[0] == '-':
+			v, err = strconv.ParseInt(f, 0, 64)
+			v += p.lastPos[i]
+		default:
+			v, err = strconv.ParseInt(f, 0, 64)
+		}
+		if err != nil {
+			return 0, err
+		}
+		p.lastPos[i] = v
+	}
+	/* The line is the last position, after a possible instruction address */
+	return int(p.lastPos[p.positions-1]), nil
+}
+
+func (p *parser) toTimeSpec(cost int64) json.TimeSpec {
+	return json.NanosecondsToTimeSpec(int64(float64(cost) * p.nsPerUnit))
+}
+
+func (p *parser) lineProfile(file string, line int) *json.LineProfile {
+	lines := p.lines[file]
+	if lines == nil {
+		lines = make(map[int]*json.LineProfile)
+		p.lines[file] = lines
+	}
+	lp := lines[line]
+	if lp == nil {
+		lp = &json.LineProfile{}
+		lines[line] = lp
+	}
+	return lp
+}
+
+func (p *parser) parseCost(line string) error {
+	fields := strings.Fields(line)
+	lineNo, err := p.parsePositions(fields)
+	if err != nil {
+		return err
+	}
+	var cost int64
+	if costs := fields[p.positions:]; p.eventIndex < len(costs) {
+		cost, err = strconv.ParseInt(costs[p.eventIndex], 10, 64)
+		if err != nil {
+			return err
+		}
+	}
+	if p.fn == nil {
+		return errors.New("cost line outside of a function")
+	}
+	if lineNo < 1 {
+		/* No line information, e.g. for functions without debug info */
+		lineNo = 1
+	}
+
+	lp := p.lineProfile(p.subFile, lineNo)
+	lp.TotalDuration.Add(p.toTimeSpec(cost))
+	lp.Hits++
+	if p.subFile == p.fn.profile.Filename && (p.fn.firstLine == 0 || lineNo < p.fn.firstLine) {
+		p.fn.firstLine = lineNo
+	}
+
+	if p.pending != nil {
+		/* A call cost line: the inclusive cost of the call made on the line */
+		callee := p.function(p.pending.callee)
+		callee.profile.Hits += json.Counter(p.pending.count)
+		key := callerKey{p.fn.profile.FullName(), p.subFile, lineNo}
+		caller := callee.callers[key]
+		if caller == nil {
+			caller = &json.FunctionCaller{At: json.Counter(lineNo), Filename: p.subFile}
+			caller.NameSpacedEntity = p.fn.profile.NameSpacedEntity
+			callee.callers[key] = caller
+			callee.profile.Callers = append(callee.profile.Callers, caller)
+		}
+		caller.Frequency += json.Counter(p.pending.count)
+		caller.TotalDuration.Add(p.toTimeSpec(cost))
+		p.fn.children += cost
+		p.pending = nil
+		return nil
+	}
+
+	p.fn.self += cost
+	p.total += cost
+	return nil
+}
+
+func (p *parser) profile() *json.Profile {
+	profile := &json.Profile{FileProfileMap: make(json.FileProfile)}
+
+	for _, f := range p.order {
+		fp := f.profile
+		if f.firstLine == 0 {
+			f.firstLine = 1
+		}
+		fp.StartLine = json.Counter(f.firstLine)
+		fp.ExclusiveDuration = p.toTimeSpec(f.children)
+		fp.InclusiveDuration = p.toTimeSpec(f.self + f.children)
+		if fp.Hits == 0 {
+			/* Never called by another function, e.g. the entry point */
+			fp.Hits = 1
+		}
+		lp := p.lineProfile(fp.Filename, f.firstLine)
+		if lp.Functions == nil {
+			lp.Functions = &json.FunctionProfileSlice{}
+		}
+		*lp.Functions = append(*lp.Functions, fp)
+	}
+
+	for file, lines := range p.lines {
+		maxLine := 0
+		for line := range lines {
+			if line > maxLine {
+				maxLine = line
+			}
+		}
+		lineProfiles := make([]*json.LineProfile, maxLine)
+		for line, lp := range lines {
+			lineProfiles[line-1] = lp
+		}
+		profile.FileProfileMap[file] = lineProfiles
+	}
+
+	total := p.summary
+	if total == 0 {
+		total = p.total
+	}
+	profile.Duration = p.toTimeSpec(total)
+	profile.Stop = profile.Duration
+	return profile
+}
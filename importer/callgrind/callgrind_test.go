@@ -0,0 +1,99 @@
+package callgrind
+
+import (
+	"strings"
+	"testing"
+)
+
+import "fprof/json"
+
+var ns = json.NanosecondsToTimeSpec
+
+var xdebugProfile = `version: 1
+creator: xdebug 3.1.0 (PHP 8.1.0)
+cmd: /srv/app/index.php
+part: 1
+positions: line
+
+events: Time_(10ns) Memory_(bytes)
+
+fl=(1) php:internal
+fn=(1) php::strlen
+5 100 0
+
+fl=(2) /srv/app/lib.php
+fn=(2) Lib::size
+3 200 16
+cfl=(1)
+cfn=(1)
+calls=2 0 0
++1 300 0
+
+fl=(3) /srv/app/index.php
+fn=(3) {main}
+2 50 0
+cfl=(2)
+cfn=(2)
+calls=1 0 0
+* 500 0
+
+summary: 1000 16
+`
+
+func TestFrom(t *testing.T) {
+	p, err := From(strings.NewReader(xdebugProfile), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Duration != ns(10000) {
+		t.Errorf("Expected a duration of 10us from the summary, got %v", p.Duration)
+	}
+
+	lib := p.FileProfileMap["/srv/app/lib.php"]
+	if len(lib) != 4 {
+		t.Fatalf("Expected 4 lines in lib.php, got %d", len(lib))
+	}
+	if lib[3].TotalDuration != ns(3000) || lib[3].Hits != 1 {
+		t.Errorf("Line 4 must carry the call cost, got %+v", lib[3])
+	}
+	size := (*lib[2].Functions)[0]
+	if size.NameSpace != "Lib" || size.Name != "size" || size.Hits != 1 || size.IsNative {
+		t.Errorf("Unexpected function %+v", size)
+	}
+	if size.InclusiveDuration != ns(5000) || size.ExclusiveDuration != ns(3000) {
+		t.Errorf("Unexpected durations of Lib::size %v %v", size.InclusiveDuration, size.ExclusiveDuration)
+	}
+	if len(size.Callers) != 1 || size.Callers[0].Name != "{main}" || size.Callers[0].At != 2 {
+		t.Errorf("Unexpected callers %+v", size.Callers)
+	}
+
+	functions := p.FileProfileMap.GetFunctionsSortedByExlusiveTime()
+	var strlen *json.FunctionProfile
+	for _, f := range functions {
+		if f != nil && f.FullName() == "php.strlen" {
+			strlen = f
+		}
+	}
+	if strlen == nil || !strlen.IsNative || strlen.Hits != 2 || strlen.OwnTime != ns(1000) {
+		t.Errorf("Unexpected php::strlen %+v", strlen)
+	}
+	if lib[3].CallsMade != 2 || lib[3].OwnTime() != ns(0) {
+		t.Errorf("Calls from line 4 not cross referenced: %+v", lib[3])
+	}
+}
+
+func TestEventSelection(t *testing.T) {
+	p, err := From(strings.NewReader(xdebugProfile), "Memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Duration != ns(16) {
+		t.Errorf("Expected the Memory totals, got %v", p.Duration)
+	}
+	if _, err := From(strings.NewReader(xdebugProfile), "Ir"); err == nil {
+		t.Errorf("Expected an error for an unknown event")
+	}
+	if _, err := From(strings.NewReader("{}"), ""); err == nil {
+		t.Errorf("Expected an error for a non-callgrind input")
+	}
+}
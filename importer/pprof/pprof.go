@@ -0,0 +1,427 @@
+/*
+Package pprof reads pprof profiles (profile.proto, usually gzipped) into a
+json.Profile so Go and other pprof profiles get fprof's source pages.
+
+Each sample adds its value to the line it was taken on and, inclusively, to
+the calling lines and functions up its stack. Hits count the samples a line
+or function appears in. The chosen sample type becomes time using its unit;
+values of other units, e.g. "count", are taken as nanoseconds. The profile
+duration is the sum of all sample values, so shares add up to 100%.
+*/
+package pprof
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+)
+
+import "fprof/json"
+
+var unitsInNs = map[string]int64{
+	"nanoseconds":  1,
+	"microseconds": 1000,
+	"milliseconds": 1000000,
+	"seconds":      1000000000,
+}
+
+type valueType struct {
+	typ, unit int64
+}
+
+type line struct {
+	functionId uint64
+	line       int64
+}
+
+type function struct {
+	name, filename int64
+	startLine      int64
+}
+
+type sample struct {
+	locationIds []uint64
+	values      []int64
+}
+
+/* The parts of profile.proto that are needed here */
+type protoProfile struct {
+	sampleTypes       []valueType
+	samples           []sample
+	locations         map[uint64][]line
+	functions         map[uint64]*function
+	strings           []string
+	timeNanos         int64
+	durationNanos     int64
+	defaultSampleType int64
+}
+
+func (p *protoProfile) str(i int64) string {
+	if i < 0 || int(i) >= len(p.strings) {
+		return ""
+	}
+	return p.strings[i]
+}
+
+func decodeValueType(b []byte) (valueType, error) {
+	var vt valueType
+	err := forEachField(b, func(f *field) error {
+		switch f.number {
+		case 1:
+			vt.typ = int64(f.varint)
+		case 2:
+			vt.unit = int64(f.varint)
+		}
+		return nil
+	})
+	return vt, err
+}
+
+func decodeSample(b []byte) (sample, error) {
+	var s sample
+	var values []uint64
+	err := forEachField(b, func(f *field) error {
+		var err error
+		switch f.number {
+		case 1:
+			s.locationIds, err = f.uint64s(s.locationIds)
+		case 2:
+			values, err = f.uint64s(values)
+		}
+		return err
+	})
+	for _, v := range values {
+		s.values = append(s.values, int64(v))
+	}
+	return s, err
+}
+
+func decodeLine(b []byte) (line, error) {
+	var l line
+	err := forEachField(b, func(f *field) error {
+		switch f.number {
+		case 1:
+			l.functionId = f.varint
+		case 2:
+			l.line = int64(f.varint)
+		}
+		return nil
+	})
+	return l, err
+}
+
+func (p *protoProfile) decodeLocation(b []byte) error {
+	var id uint64
+	var lines []line
+	err := forEachField(b, func(f *field) error {
+		switch f.number {
+		case 1:
+			id = f.varint
+		case 4:
+			l, err := decodeLine(f.bytes)
+			if err != nil {
+				return err
+			}
+			lines = append(lines, l)
+		}
+		return nil
+	})
+	p.locations[id] = lines
+	return err
+}
+
+func (p *protoProfile) decodeFunction(b []byte) error {
+	var id uint64
+	fn := &function{}
+	err := forEachField(b, func(f *field) error {
+		switch f.number {
+		case 1:
+			id = f.varint
+		case 2:
+			fn.name = int64(f.varint)
+		case 4:
+			fn.filename = int64(f.varint)
+		case 5:
+			fn.startLine = int64(f.varint)
+		}
+		return nil
+	})
+	p.functions[id] = fn
+	return err
+}
+
+func decodeProfile(b []byte) (*protoProfile, error) {
+	p := &protoProfile{
+		locations: make(map[uint64][]line),
+		functions: make(map[uint64]*function),
+	}
+	err := forEachField(b, func(f *field) error {
+		var err error
+		switch f.number {
+		case 1:
+			var vt valueType
+			vt, err = decodeValueType(f.bytes)
+			p.sampleTypes = append(p.sampleTypes, vt)
+		case 2:
+			var s sample
+			s, err = decodeSample(f.bytes)
+			p.samples = append(p.samples, s)
+		case 4:
+			err = p.decodeLocation(f.bytes)
+		case 5:
+			err = p.decodeFunction(f.bytes)
+		case 6:
+			p.strings = append(p.strings, string(f.bytes))
+		case 9:
+			p.timeNanos = int64(f.varint)
+		case 10:
+			p.durationNanos = int64(f.varint)
+		case 14:
+			p.defaultSampleType = int64(f.varint)
+		}
+		return err
+	})
+	return p, err
+}
+
+/* Picks the named sample type, else the default one, else the last one */
+func (p *protoProfile) sampleIndex(sampleType string) (int, error) {
+	if len(p.sampleTypes) == 0 {
+		return 0, errors.New("pprof: profile has no sample types")
+	}
+	names := []string{}
+	for i, vt := range p.sampleTypes {
+		name := p.str(vt.typ)
+		if sampleType != "" && name == sampleType {
+			return i, nil
+		}
+		if sampleType == "" && p.defaultSampleType != 0 && vt.typ == p.defaultSampleType {
+			return i, nil
+		}
+		names = append(names, name)
+	}
+	if sampleType != "" {
+		return 0, fmt.Errorf("pprof: no sample type %s, have: %v", sampleType, names)
+	}
+	return len(p.sampleTypes) - 1, nil
+}
+
+/* A source location of a stack frame */
+type frame struct {
+	fn   uint64
+	file string
+	line int
+}
+
+type functionProfile struct {
+	profile   *json.FunctionProfile
+	self      int64
+	inclusive int64
+	firstLine int
+	callers   map[frame]*json.FunctionCaller
+}
+
+type builder struct {
+	p         *protoProfile
+	nsPerUnit int64
+	lines     map[string]map[int]*json.LineProfile
+	functions map[uint64]*functionProfile
+	order     []*functionProfile
+	total     int64
+}
+
+func (b *builder) lineProfile(file string, lineNo int) *json.LineProfile {
+	lines := b.lines[file]
+	if lines == nil {
+		lines = make(map[int]*json.LineProfile)
+		b.lines[file] = lines
+	}
+	lp := lines[lineNo]
+	if lp == nil {
+		lp = &json.LineProfile{}
+		lines[lineNo] = lp
+	}
+	return lp
+}
+
+func (b *builder) function(id uint64) *functionProfile {
+	f := b.functions[id]
+	if f == nil {
+		fn := b.p.functions[id]
+		if fn == nil {
+			fn = &function{}
+		}
+		fp := &json.FunctionProfile{}
+		fp.Name = b.p.str(fn.name)
+		if fp.Name == "" {
+			fp.Name = fmt.Sprintf("function#%d", id)
+		}
+		fp.Filename = b.p.str(fn.filename)
+		fp.IsNative = !path.IsAbs(fp.Filename)
+		f = &functionProfile{profile: fp, firstLine: int(fn.startLine), callers: make(map[frame]*json.FunctionCaller)}
+		b.functions[id] = f
+		b.order = append(b.order, f)
+	}
+	return f
+}
+
+/* Expands a sample's locations into frames, leaf first */
+func (b *builder) frames(s sample) []frame {
+	frames := []frame{}
+	for _, id := range s.locationIds {
+		for _, l := range b.p.locations[id] {
+			fn := b.function(l.functionId)
+			lineNo := int(l.line)
+			if lineNo < 1 {
+				lineNo = 1
+			}
+			frames = append(frames, frame{l.functionId, fn.profile.Filename, lineNo})
+		}
+	}
+	return frames
+}
+
+func (b *builder) addSample(s sample, index int) {
+	if index >= len(s.values) {
+		return
+	}
+	v := s.values[index] * b.nsPerUnit
+	cost := json.NanosecondsToTimeSpec(v)
+	frames := b.frames(s)
+	if len(frames) == 0 {
+		return
+	}
+	b.total += v
+
+	/* Recursion puts a line or function on the stack more than once */
+	seenLines := make(map[frame]bool)
+	seenFunctions := make(map[uint64]bool)
+	seenCalls := make(map[[2]frame]bool)
+	for i, fr := range frames {
+		lineKey := frame{0, fr.file, fr.line}
+		if !seenLines[lineKey] {
+			seenLines[lineKey] = true
+			lp := b.lineProfile(fr.file, fr.line)
+			lp.Hits++
+			lp.TotalDuration.Add(cost)
+		}
+
+		f := b.function(fr.fn)
+		if i == 0 {
+			f.self += v
+		}
+		if !seenFunctions[fr.fn] {
+			seenFunctions[fr.fn] = true
+			f.inclusive += v
+			f.profile.Hits++
+		}
+		if f.firstLine == 0 || fr.line < f.firstLine {
+			f.firstLine = fr.line
+		}
+
+		if i+1 < len(frames) {
+			caller := frames[i+1]
+			call := [2]frame{caller, {fr.fn, "", 0}}
+			if seenCalls[call] {
+				continue
+			}
+			seenCalls[call] = true
+			c := f.callers[caller]
+			if c == nil {
+				c = &json.FunctionCaller{At: json.Counter(caller.line), Filename: caller.file}
+				c.NameSpacedEntity = b.function(caller.fn).profile.NameSpacedEntity
+				f.callers[caller] = c
+				f.profile.Callers = append(f.profile.Callers, c)
+			}
+			c.Frequency++
+			c.TotalDuration.Add(cost)
+		}
+	}
+}
+
+func (b *builder) profile() *json.Profile {
+	profile := &json.Profile{FileProfileMap: make(json.FileProfile)}
+	for _, f := range b.order {
+		fp := f.profile
+		if f.firstLine < 1 {
+			f.firstLine = 1
+		}
+		fp.StartLine = json.Counter(f.firstLine)
+		fp.InclusiveDuration = json.NanosecondsToTimeSpec(f.inclusive)
+		/* As in ferite profiles, the time spent in the functions called */
+		fp.ExclusiveDuration = json.NanosecondsToTimeSpec(f.inclusive - f.self)
+		lp := b.lineProfile(fp.Filename, f.firstLine)
+		if lp.Functions == nil {
+			lp.Functions = &json.FunctionProfileSlice{}
+		}
+		*lp.Functions = append(*lp.Functions, fp)
+	}
+	for file, lines := range b.lines {
+		maxLine := 0
+		for lineNo := range lines {
+			if lineNo > maxLine {
+				maxLine = lineNo
+			}
+		}
+		lineProfiles := make([]*json.LineProfile, maxLine)
+		for lineNo, lp := range lines {
+			lineProfiles[lineNo-1] = lp
+		}
+		profile.FileProfileMap[file] = lineProfiles
+	}
+
+	profile.Start = json.NanosecondsToTimeSpec(b.p.timeNanos)
+	profile.Stop = json.NanosecondsToTimeSpec(b.p.timeNanos + b.p.durationNanos)
+	profile.Duration = json.NanosecondsToTimeSpec(b.total)
+	return profile
+}
+
+/*
+ * From reads a pprof profile, gzipped or not, taking the values of the
+ * named sample type as duration. An empty sample type selects the profile's
+ * default sample type, or the last one.
+ */
+func From(stream io.Reader, sampleType string) (*json.Profile, error) {
+	data, err := ioutil.ReadAll(stream)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		data, err = ioutil.ReadAll(gz)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	p, err := decodeProfile(data)
+	if err != nil {
+		return nil, err
+	}
+	index, err := p.sampleIndex(sampleType)
+	if err != nil {
+		return nil, err
+	}
+	nsPerUnit, ok := unitsInNs[p.str(p.sampleTypes[index].unit)]
+	if !ok {
+		nsPerUnit = 1
+	}
+
+	b := &builder{
+		p:         p,
+		nsPerUnit: nsPerUnit,
+		lines:     make(map[string]map[int]*json.LineProfile),
+		functions: make(map[uint64]*functionProfile),
+	}
+	for _, s := range p.samples {
+		b.addSample(s, index)
+	}
+	return b.profile(), nil
+}
@@ -0,0 +1,143 @@
+package pprof
+
+import (
+	"bytes"
+	"compress/gzip"
+	"runtime/pprof"
+	"testing"
+)
+
+import "fprof/json"
+
+var ns = json.NanosecondsToTimeSpec
+
+/* Minimal protocol buffers encoder to build test profiles */
+type encoder struct {
+	bytes.Buffer
+}
+
+func (e *encoder) varint(v uint64) {
+	for v >= 0x80 {
+		e.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	e.WriteByte(byte(v))
+}
+
+func (e *encoder) int(number int, v uint64) {
+	e.varint(uint64(number) << 3)
+	e.varint(v)
+}
+
+func (e *encoder) message(number int, m *encoder) {
+	e.bytes(number, m.Bytes())
+}
+
+func (e *encoder) bytes(number int, b []byte) {
+	e.varint(uint64(number)<<3 | wireBytes)
+	e.varint(uint64(len(b)))
+	e.Write(b)
+}
+
+func (e *encoder) packed(number int, values ...uint64) {
+	var p encoder
+	for _, v := range values {
+		p.varint(v)
+	}
+	e.bytes(number, p.Bytes())
+}
+
+func msg(build func(e *encoder)) *encoder {
+	e := &encoder{}
+	build(e)
+	return e
+}
+
+/*
+ * main (/app/main.go:10) calls work (/app/work.go:5) which spends 30ms,
+ * main itself spends 10ms at line 11.
+ */
+func testProfile() []byte {
+	strs := []string{"", "samples", "count", "cpu", "nanoseconds", "main", "/app/main.go", "work", "/app/work.go"}
+	p := msg(func(e *encoder) {
+		e.message(1, msg(func(e *encoder) { e.int(1, 1); e.int(2, 2) }))
+		e.message(1, msg(func(e *encoder) { e.int(1, 3); e.int(2, 4) }))
+		e.message(2, msg(func(e *encoder) { e.packed(1, 1, 2); e.packed(2, 3, 30000000) }))
+		e.message(2, msg(func(e *encoder) { e.int(1, 3); e.packed(2, 1, 10000000) }))
+		for _, l := range [][3]uint64{{1, 2, 5}, {2, 1, 10}, {3, 1, 11}} {
+			l := l
+			e.message(4, msg(func(e *encoder) {
+				e.int(1, l[0])
+				e.message(4, msg(func(e *encoder) { e.int(1, l[1]); e.int(2, l[2]) }))
+			}))
+		}
+		e.message(5, msg(func(e *encoder) { e.int(1, 1); e.int(2, 5); e.int(4, 6); e.int(5, 9) }))
+		e.message(5, msg(func(e *encoder) { e.int(1, 2); e.int(2, 7); e.int(4, 8); e.int(5, 3) }))
+		for _, s := range strs {
+			e.bytes(6, []byte(s))
+		}
+		e.int(9, 1000000000)
+		e.int(10, 50000000)
+	})
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(p.Bytes())
+	w.Close()
+	return gz.Bytes()
+}
+
+func TestFrom(t *testing.T) {
+	p, err := From(bytes.NewReader(testProfile()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Duration != ns(40000000) || p.Start != ns(1000000000) || p.Stop != ns(1050000000) {
+		t.Errorf("Unexpected times %v %v %v", p.Start, p.Stop, p.Duration)
+	}
+	mainLines := p.FileProfileMap["/app/main.go"]
+	if len(mainLines) != 11 {
+		t.Fatalf("Expected 11 lines in main.go, got %d", len(mainLines))
+	}
+	if mainLines[9].TotalDuration != ns(30000000) || mainLines[10].TotalDuration != ns(10000000) {
+		t.Errorf("Unexpected line times %+v %+v", mainLines[9], mainLines[10])
+	}
+	main := (*mainLines[8].Functions)[0]
+	if main.Name != "main" || main.InclusiveDuration != ns(40000000) || main.ExclusiveDuration != ns(30000000) {
+		t.Errorf("Unexpected main %+v", main)
+	}
+
+	functions := p.FileProfileMap.GetFunctionsSortedByExlusiveTime()
+	work := functions[0]
+	if work.Name != "work" || work.OwnTime != ns(30000000) || work.StartLine != 3 {
+		t.Errorf("Unexpected work %+v", work)
+	}
+	if len(work.Callers) != 1 || work.Callers[0].Name != "main" || work.Callers[0].At != 10 {
+		t.Errorf("Unexpected callers of work %+v", work.Callers)
+	}
+	if mainLines[9].OwnTime() != ns(0) || mainLines[9].CallsMade != 1 {
+		t.Errorf("Call from main.go:10 not cross referenced: %+v", mainLines[9])
+	}
+}
+
+func TestSampleType(t *testing.T) {
+	p, err := From(bytes.NewReader(testProfile()), "samples")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Duration != ns(4) {
+		t.Errorf("Expected 4 samples as duration, got %v", p.Duration)
+	}
+	if _, err := From(bytes.NewReader(testProfile()), "alloc_space"); err == nil {
+		t.Errorf("Expected an error for an unknown sample type")
+	}
+}
+
+func TestRuntimeHeapProfile(t *testing.T) {
+	var b bytes.Buffer
+	if err := pprof.Lookup("heap").WriteTo(&b, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := From(&b, "alloc_space"); err != nil {
+		t.Error(err)
+	}
+}
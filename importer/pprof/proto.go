@@ -0,0 +1,119 @@
+package pprof
+
+import (
+	"errors"
+)
+
+/* Just enough of the protocol buffers wire format to read profile.proto */
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("pprof: truncated protocol buffer")
+
+type field struct {
+	number int
+	wire   int
+	varint uint64
+	bytes  []byte
+}
+
+type decoder struct {
+	b []byte
+}
+
+func (d *decoder) varint() (uint64, error) {
+	var v uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		if len(d.b) == 0 {
+			return 0, errTruncated
+		}
+		c := d.b[0]
+		d.b = d.b[1:]
+		v |= uint64(c&0x7f) << shift
+		if c < 0x80 {
+			return v, nil
+		}
+	}
+	return 0, errors.New("pprof: varint overflow")
+}
+
+func (d *decoder) skip(n int) ([]byte, error) {
+	if n < 0 || len(d.b) < n {
+		return nil, errTruncated
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b, nil
+}
+
+/* Decodes the next field, returning false at the end of the message */
+func (d *decoder) next(f *field) (bool, error) {
+	if len(d.b) == 0 {
+		return false, nil
+	}
+	key, err := d.varint()
+	if err != nil {
+		return false, err
+	}
+	f.number, f.wire = int(key>>3), int(key&7)
+	f.varint, f.bytes = 0, nil
+	switch f.wire {
+	case wireVarint:
+		f.varint, err = d.varint()
+	case wireFixed64:
+		_, err = d.skip(8)
+	case wireFixed32:
+		_, err = d.skip(4)
+	case wireBytes:
+		var n uint64
+		n, err = d.varint()
+		if err == nil {
+			f.bytes, err = d.skip(int(n))
+		}
+	default:
+		err = errors.New("pprof: unsupported wire type")
+	}
+	return err == nil, err
+}
+
+/* Appends the values of a repeated integer field, packed or not */
+func (f *field) uint64s(values []uint64) ([]uint64, error) {
+	if f.wire == wireVarint {
+		return append(values, f.varint), nil
+	}
+	if f.wire != wireBytes {
+		return values, errors.New("pprof: unexpected wire type for repeated integers")
+	}
+	d := decoder{f.bytes}
+	for len(d.b) > 0 {
+		v, err := d.varint()
+		if err != nil {
+			return values, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+/* Calls fn for every field of the message in b */
+func forEachField(b []byte, fn func(f *field) error) error {
+	d := decoder{b}
+	var f field
+	for {
+		more, err := d.next(&f)
+		if err != nil {
+			return err
+		}
+		if !more {
+			return nil
+		}
+		if err := fn(&f); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"fprof/importer/callgrind"
+	"fprof/importer/pprof"
+	"fprof/json"
+	"fprof/log"
+)
+
+var inputFormats = "auto, ferite, callgrind, pprof"
+var inputFormat = "auto"
+var inputEvent = ""
+var salvage = false
+var inputThread = ""
+
+func addInputFlags(fs *flag.FlagSet) {
+	fs.StringVar(&inputFormat, "input", inputFormat, "Profile format, one of: "+inputFormats)
+	fs.StringVar(&inputEvent, "event", inputEvent, "callgrind: Event, pprof: sample type to use as duration")
+	fs.StringVar(&inputThread, "thread", inputThread, "Use the records of the given thread only")
+	fs.BoolVar(&salvage, "salvage", salvage, "ferite: Report what can be read from a truncated profile")
+}
+
+/* Tells the profile format from its first bytes */
+func detectFormat(in *bufio.Reader) string {
+	head, _ := in.Peek(512)
+	head = bytes.TrimLeft(head, " \t\r\n")
+	if len(head) > 0 && head[0] == '{' {
+		return "ferite"
+	}
+	if len(head) > 1 && head[0] == 0x1f && head[1] == 0x8b {
+		/* pprof profiles are gzipped */
+		return "pprof"
+	}
+	return "callgrind"
+}
+
+func decodeProfile(in io.Reader) (*json.Profile, error) {
+	r := bufio.NewReader(in)
+	format := inputFormat
+	if format == "auto" {
+		format = detectFormat(r)
+		log.Println("Reading profile as", format)
+	}
+	switch format {
+	case "ferite":
+		if salvage {
+			return json.Salvage(r)
+		}
+		return json.From(r)
+	case "callgrind":
+		return callgrind.From(r, inputEvent)
+	case "pprof":
+		return pprof.From(r, inputEvent)
+	}
+	log.Fatal("Unknown input format ", format, ", expecting one of: ", inputFormats)
+	return nil, nil
+}
+
+func readProfile(jsonfile string) *json.Profile {
+	in := os.Stdin
+	if jsonfile != "-" {
+		var err error
+		in, err = os.Open(jsonfile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer in.Close()
+	}
+	profile, err := decodeProfile(in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if inputThread != "" {
+		thread := profile.Thread(inputThread)
+		if thread == nil {
+			log.Fatal("No thread ", inputThread, " in the profile, have: ", profile.ThreadIds())
+		}
+		profile = thread
+	}
+	for _, warning := range profile.Warnings {
+		fmt.Fprintln(os.Stderr, "Warning:", warning)
+	}
+	return profile
+}
@@ -0,0 +1,98 @@
+package json
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	EventEnter = "enter"
+	EventExit  = "exit"
+)
+
+/*
+ * An entry of the optional "events" log of a profile: a function entered or
+ * exited at a point in wall time. Exits need only name the thread, they are
+ * paired with the last enter of the thread.
+ */
+type Event struct {
+	Time   TimeSpec `json:"time"`
+	Type   string   `json:"type"`
+	Thread string   `json:"thread"`
+	NameSpacedEntity
+	Filename string  `json:"file"`
+	Line     Counter `json:"line"`
+}
+
+/* A function running from Start to Stop, Depth calls deep in its thread */
+type Activation struct {
+	Thread string
+	NameSpacedEntity
+	Filename    string
+	Line        Counter
+	Start, Stop TimeSpec
+	Depth       int
+}
+
+func (a *Activation) Duration() TimeSpec {
+	d := a.Stop
+	d.Subtract(a.Start)
+	return d
+}
+
+/*
+ * Activations pairs the enter and exit events of the event log, per thread.
+ * Functions still running at the end of the log stop with the profile, or
+ * with the last event when the profile has no stop time. Events that do not
+ * pair up are reported as warnings.
+ */
+func (p *Profile) Activations() ([]*Activation, []string) {
+	events := make([]*Event, len(p.Events))
+	for i := range p.Events {
+		events[i] = &p.Events[i]
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.IsLessThan(&events[j].Time)
+	})
+
+	activations := []*Activation{}
+	warnings := []string{}
+	stacks := make(map[string][]*Activation)
+	threads := []string{}
+	for _, e := range events {
+		stack, known := stacks[e.Thread]
+		if !known {
+			threads = append(threads, e.Thread)
+		}
+		switch e.Type {
+		case EventEnter:
+			a := &Activation{e.Thread, e.NameSpacedEntity, e.Filename, e.Line, e.Time, e.Time, len(stack)}
+			activations = append(activations, a)
+			stack = append(stack, a)
+		case EventExit:
+			if len(stack) == 0 {
+				warnings = append(warnings, fmt.Sprintf("Exit of %s at %v without an enter event", e.FullName(), e.Time))
+				break
+			}
+			stack[len(stack)-1].Stop = e.Time
+			stack = stack[:len(stack)-1]
+		default:
+			warnings = append(warnings, fmt.Sprintf("Unknown event type %s", e.Type))
+		}
+		stacks[e.Thread] = stack
+	}
+
+	end := p.Stop
+	if len(events) > 0 && (end == TimeSpec{} || end.IsLessThan(&events[len(events)-1].Time)) {
+		end = events[len(events)-1].Time
+	}
+	for _, thread := range threads {
+		if n := len(stacks[thread]); n > 0 {
+			warnings = append(warnings, fmt.Sprintf("%d function(s) of thread %s did not exit before the end of the profile", n, thread))
+		}
+		for _, a := range stacks[thread] {
+			a.Stop = end
+		}
+	}
+	return activations, warnings
+}
@@ -0,0 +1,35 @@
+package json
+
+import (
+	"testing"
+)
+
+func event(ns int64, typ, thread, name string) Event {
+	e := Event{Time: NanosecondsToTimeSpec(ns), Type: typ, Thread: thread}
+	e.Name = name
+	return e
+}
+
+func TestActivations(tt *testing.T) {
+	t = tt
+	p := Profile{Stop: NanosecondsToTimeSpec(100)}
+	p.Events = []Event{
+		event(10, EventEnter, "1", "main"),
+		event(20, EventEnter, "1", "f"),
+		event(15, EventEnter, "2", "worker"),
+		event(30, EventExit, "1", ""),
+		event(40, EventExit, "2", ""),
+		event(50, EventExit, "2", ""),
+	}
+	activations, warnings := p.Activations()
+	assertEqual(len(activations), 3, "Activations")
+
+	main, worker, f := activations[0], activations[1], activations[2]
+	assertEqual(main.Name, "main", "Ordered by start time")
+	assertEqual(main.Stop, NanosecondsToTimeSpec(100), "Unfinished activations stop with the profile")
+	assertEqual(f.Depth, 1, "f is called by main")
+	assertEqual(f.Duration(), NanosecondsToTimeSpec(10), "Duration of f")
+	assertEqual(worker.Depth, 0, "Threads have stacks of their own")
+	assertEqual(worker.Stop, NanosecondsToTimeSpec(40), "Stop of worker")
+	assertEqual(len(warnings), 2, "Unpaired exit and unfinished main: %v", warnings)
+}
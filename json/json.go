@@ -21,6 +21,14 @@ type Profile struct {
 	Stop           TimeSpec    `json:"stop"`
 	Duration       TimeSpec    `json:"duration"`
 	FileProfileMap FileProfile `json:"files"`
+	/* Optional, for multi-threaded programs */
+	Threads map[string]*ThreadProfile `json:"threads"`
+	/* Optional custom metrics of lines and functions */
+	Metrics []MetricDeclaration `json:"metrics"`
+	/* Optional log of function enter and exit events */
+	Events []Event `json:"events"`
+	/* Problems found while reading the profile, shown with the reports */
+	Warnings []string `json:"-"`
 }
 
 type FileProfile map[string][]*LineProfile
@@ -48,6 +56,7 @@ type LineProfile struct {
 	Functions *FunctionProfileSlice `json:"functions"`
 	HitCount
 	TotalDuration TimeSpec `json:"total_duration"`
+	Metrics       Metrics  `json:"metrics"`
 	FunctionCalls FunctionCallSlice
 	/* Cumulative of calls in FunctionCalls: */
 	CallsMade       Counter
@@ -62,6 +71,7 @@ type FunctionProfile struct {
 	InclusiveDuration TimeSpec            `json:"inclusive_duration"`
 	IsNative          bool                `json:"is_native"`
 	Callers           FunctionCallerSlice `json:"callers"`
+	Metrics           Metrics             `json:"metrics"`
 	HitCount
 	OwnTime TimeSpec
 }
@@ -129,6 +139,22 @@ func (f *FunctionProfile) CalculateOwnTime() {
 	f.OwnTime.Subtract(f.ExclusiveDuration)
 }
 
+/* Percentage of the inclusive duration spent in the function itself */
+func (f *FunctionProfile) OwnTimeRatio() float64 {
+	inclMS := f.InclusiveDuration.InMilliseconds()
+	if inclMS > 0 {
+		return f.OwnTime.InMilliseconds() * 100 / inclMS
+	}
+	return 0
+}
+
+/* Time spent on the line itself, excluding the time in functions it called */
+func (lp *LineProfile) OwnTime() TimeSpec {
+	ownTime := lp.TotalDuration
+	ownTime.Subtract(lp.TimeInFunctions)
+	return ownTime
+}
+
 func (ts *TimeSpec) Subtract(other TimeSpec) {
 	if ts.Nsec < other.Nsec {
 		ts.Sec--
@@ -188,10 +214,24 @@ func (ts TimeSpec) NonZeroMsOrNone() string {
 	return ""
 }
 
+func NanosecondsToTimeSpec(ns int64) TimeSpec {
+	return TimeSpec{ns / ONE_BILLION, ns % ONE_BILLION}
+}
+
+func (ts TimeSpec) InNanoseconds() int64 {
+	return ts.Sec*ONE_BILLION + ts.Nsec
+}
+
 func (ts TimeSpec) InSeconds() float64 {
 	return float64(ts.Sec) + float64(ts.Nsec)/1000000000
 }
 
+/* Completes a freshly decoded profile */
+func (p *Profile) prepare() {
+	p.combineThreads()
+	p.checkMetrics()
+}
+
 func DecodeFromBytes(b []byte) *Profile {
 	var o Profile
 
@@ -200,6 +240,7 @@ func DecodeFromBytes(b []byte) *Profile {
 		log.Fatal(err)
 		return nil
 	}
+	o.prepare()
 	return &o
 }
 
@@ -215,6 +256,7 @@ func From(stream io.Reader) (*Profile, error) {
 	if err != nil {
 		return nil, err
 	}
+	o.prepare()
 	return &o, nil
 
 }
@@ -287,7 +329,9 @@ func (fp FileProfile) injectCallerDurations(function *FunctionProfile) {
 	callers := function.Callers
 	for _, caller := range callers {
 		lines := fp[caller.Filename]
-		if lines != nil {
+		if lines != nil && (caller.At < 1 || int(caller.At) > len(lines)) {
+			log.Printf("?? No line %d in the line profiles for [%s] ??", caller.At, caller.Filename)
+		} else if lines != nil {
 			if lines[caller.At-1] == nil {
 				lines[caller.At-1] = &LineProfile{}
 			}
@@ -0,0 +1,93 @@
+package json
+
+import (
+	"fmt"
+	"math"
+)
+
+/* How the values of a metric add up, e.g. over threads or over a file */
+const (
+	AggregateSum = "sum"
+	AggregateMax = "max"
+	AggregateMin = "min"
+)
+
+/*
+ * A custom metric recorded per line and function, e.g. bytes allocated,
+ * declared in the "metrics" list of the profile header. Lines and functions
+ * carry the values in their "metrics" maps, keyed by Name.
+ */
+type MetricDeclaration struct {
+	Name        string `json:"name"`
+	Unit        string `json:"unit"`
+	Aggregation string `json:"aggregation"`
+}
+
+type Metrics map[string]float64
+
+/* Column title of the metric, e.g. "allocated (bytes)" */
+func (m *MetricDeclaration) Title() string {
+	if m.Unit == "" {
+		return m.Name
+	}
+	return fmt.Sprintf("%s (%s)", m.Name, m.Unit)
+}
+
+func (m *MetricDeclaration) Aggregate(a, b float64) float64 {
+	switch m.Aggregation {
+	case AggregateMax:
+		return math.Max(a, b)
+	case AggregateMin:
+		return math.Min(a, b)
+	}
+	return a + b
+}
+
+/* Tells whether the value was recorded, as opposed to being zero */
+func (metrics Metrics) Has(name string) bool {
+	_, ok := metrics[name]
+	return ok
+}
+
+/* Adds the values of other, using the declared aggregations */
+func (metrics *Metrics) Add(other Metrics, declarations []MetricDeclaration) {
+	if len(other) == 0 {
+		return
+	}
+	if *metrics == nil {
+		*metrics = Metrics{}
+	}
+	for name, v := range other {
+		existing, ok := (*metrics)[name]
+		if !ok {
+			(*metrics)[name] = v
+			continue
+		}
+		aggregated := existing + v
+		for i := range declarations {
+			if declarations[i].Name == name {
+				aggregated = declarations[i].Aggregate(existing, v)
+			}
+		}
+		(*metrics)[name] = aggregated
+	}
+}
+
+/* Warns about declarations the reports cannot make sense of */
+func (p *Profile) checkMetrics() {
+	seen := make(map[string]bool)
+	for _, m := range p.Metrics {
+		switch {
+		case m.Name == "":
+			p.Warnings = append(p.Warnings, "A metric is declared without a name")
+		case seen[m.Name]:
+			p.Warnings = append(p.Warnings, fmt.Sprintf("Metric %s is declared more than once", m.Name))
+		}
+		seen[m.Name] = true
+		switch m.Aggregation {
+		case "", AggregateSum, AggregateMax, AggregateMin:
+		default:
+			p.Warnings = append(p.Warnings, fmt.Sprintf("Unknown aggregation %s of metric %s, summing it instead", m.Aggregation, m.Name))
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package json
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMetricAggregate(tt *testing.T) {
+	t = tt
+	sum := MetricDeclaration{Name: "allocated"}
+	max := MetricDeclaration{Name: "peak", Aggregation: AggregateMax}
+	min := MetricDeclaration{Name: "free", Aggregation: AggregateMin}
+	assertEqual(sum.Aggregate(2, 3), 5.0, "Metrics are summed by default")
+	assertEqual(max.Aggregate(2, 3), 3.0, "max")
+	assertEqual(min.Aggregate(2, 3), 2.0, "min")
+	assertEqual(max.Title(), "peak", "Title without unit")
+	max.Unit = "bytes"
+	assertEqual(max.Title(), "peak (bytes)", "Title with unit")
+
+	m := Metrics{"peak": 5, "allocated": 1}
+	m.Add(Metrics{"peak": 7, "allocated": 2, "other": 1}, []MetricDeclaration{sum, max})
+	assertEqual(m, Metrics{"peak": 7, "allocated": 3, "other": 1}, "Add")
+}
+
+func TestDecodeMetrics(tt *testing.T) {
+	t = tt
+	p, err := From(strings.NewReader(`{
+		"metrics": [
+			{ "name": "allocated", "unit": "bytes", "aggregation": "sum" },
+			{ "name": "peak", "unit": "bytes", "aggregation": "median" }
+		],
+		"files": {
+			"/a": [
+				{
+					"functions": [ { "name": "f", "metrics": { "allocated": 4096 } } ],
+					"hits": 1,
+					"metrics": { "allocated": 1024, "peak": 512 }
+				}
+			]
+		}
+	}`))
+	logFailIf(err != nil, "From: %v", err)
+	assertEqual(len(p.Metrics), 2, "Declared metrics")
+	lp := p.FileProfileMap["/a"][0]
+	assertEqual(lp.Metrics["allocated"], 1024.0, "Line metric")
+	assertEqual((*lp.Functions)[0].Metrics["allocated"], 4096.0, "Function metric")
+	logFailIf(!lp.Metrics.Has("peak") || lp.Metrics.Has("syscalls"), "Has")
+	assertEqual(len(p.Warnings), 1, "Unknown aggregation must be warned about")
+}
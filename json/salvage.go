@@ -0,0 +1,249 @@
+package json
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+ * salvager walks a possibly truncated ferite profile token by token, keeping
+ * every file entry, line record and function record that is complete. Once
+ * the input breaks off, err is set and nothing more is read.
+ */
+type salvager struct {
+	dec       *json.Decoder
+	err       error
+	warnings  []string
+	lines     int
+	functions int
+}
+
+func (s *salvager) fail(err error) bool {
+	if s.err == nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		s.err = fmt.Errorf("%v at byte %d", err, s.dec.InputOffset())
+	}
+	return false
+}
+
+func (s *salvager) warn(format string, args ...interface{}) {
+	s.warnings = append(s.warnings, fmt.Sprintf(format, args...))
+}
+
+func (s *salvager) token() (json.Token, bool) {
+	if s.err != nil {
+		return nil, false
+	}
+	t, err := s.dec.Token()
+	if err != nil {
+		return nil, s.fail(err)
+	}
+	return t, true
+}
+
+func (s *salvager) delim(want json.Delim) bool {
+	t, ok := s.token()
+	if !ok {
+		return false
+	}
+	if d, isDelim := t.(json.Delim); !isDelim || d != want {
+		return s.fail(fmt.Errorf("expected %v, got %v", want, t))
+	}
+	return true
+}
+
+func (s *salvager) decode(v interface{}) bool {
+	if s.err != nil {
+		return false
+	}
+	if err := s.dec.Decode(v); err != nil {
+		return s.fail(err)
+	}
+	return true
+}
+
+func (s *salvager) skip() bool {
+	var ignored json.RawMessage
+	return s.decode(&ignored)
+}
+
+/* Reads the members of an object whose '{' has been read already */
+func (s *salvager) objectBody(member func(key string) bool) bool {
+	for s.err == nil && s.dec.More() {
+		t, ok := s.token()
+		if !ok {
+			return false
+		}
+		key, isString := t.(string)
+		if !isString {
+			return s.fail(fmt.Errorf("expected object key, got %v", t))
+		}
+		if !member(key) {
+			return false
+		}
+	}
+	return s.delim('}')
+}
+
+func (s *salvager) object(member func(key string) bool) bool {
+	return s.delim('{') && s.objectBody(member)
+}
+
+/* Reads the elements of an array, or null */
+func (s *salvager) array(element func(i int) bool) bool {
+	t, ok := s.token()
+	if !ok {
+		return false
+	}
+	if t == nil {
+		return true
+	}
+	if d, isDelim := t.(json.Delim); !isDelim || d != '[' {
+		return s.fail(fmt.Errorf("expected array, got %v", t))
+	}
+	for i := 0; s.err == nil && s.dec.More(); i++ {
+		if !element(i) {
+			return false
+		}
+	}
+	return s.delim(']')
+}
+
+func (s *salvager) functionRecords(lp *LineProfile) bool {
+	functions := FunctionProfileSlice{}
+	lp.Functions = &functions
+	return s.array(func(i int) bool {
+		var f FunctionProfile
+		if !s.decode(&f) {
+			return false
+		}
+		functions = append(functions, &f)
+		s.functions++
+		return true
+	})
+}
+
+/* Returns the line record, nil for null or when too little of it is left */
+func (s *salvager) line(file string, lineNo int) (*LineProfile, bool) {
+	t, ok := s.token()
+	if !ok {
+		return nil, false
+	}
+	if t == nil {
+		return nil, true
+	}
+	if d, isDelim := t.(json.Delim); !isDelim || d != '{' {
+		return nil, s.fail(fmt.Errorf("expected line record, got %v", t))
+	}
+
+	lp := &LineProfile{}
+	complete := s.objectBody(func(key string) bool {
+		switch key {
+		case "functions":
+			return s.functionRecords(lp)
+		case "hits":
+			return s.decode(&lp.Hits)
+		case "total_duration":
+			return s.decode(&lp.TotalDuration)
+		case "metrics":
+			return s.decode(&lp.Metrics)
+		}
+		return s.skip()
+	})
+	if complete {
+		s.lines++
+		return lp, true
+	}
+	if lp.Functions != nil && len(*lp.Functions) > 0 {
+		s.warn("Line %d of %s is incomplete, kept its %d function record(s) only", lineNo, file, len(*lp.Functions))
+		return lp, false
+	}
+	s.warn("Dropped the incomplete record of line %d of %s", lineNo, file)
+	return nil, false
+}
+
+func (s *salvager) files(fileProfiles FileProfile) bool {
+	return s.object(func(file string) bool {
+		lines := []*LineProfile{}
+		complete := s.array(func(i int) bool {
+			lp, ok := s.line(file, i+1)
+			if ok || lp != nil {
+				lines = append(lines, lp)
+			}
+			return ok
+		})
+		if len(lines) > 0 || complete {
+			fileProfiles[file] = lines
+		}
+		if !complete {
+			s.warn("%s is incomplete, kept %d line record(s)", file, len(lines))
+		}
+		return complete
+	})
+}
+
+/*
+ * Salvage decodes as much as possible of a profile that was cut off while
+ * being written, e.g. because ferite crashed. What could not be recovered is
+ * listed in the profile's Warnings.
+ */
+func Salvage(stream io.Reader) (*Profile, error) {
+	s := &salvager{dec: json.NewDecoder(stream)}
+	o := Profile{FileProfileMap: FileProfile{}}
+	seen := make(map[string]bool)
+
+	s.object(func(key string) bool {
+		ok := false
+		switch key {
+		case "start":
+			ok = s.decode(&o.Start)
+		case "stop":
+			ok = s.decode(&o.Stop)
+		case "duration":
+			ok = s.decode(&o.Duration)
+		case "files":
+			ok = s.files(o.FileProfileMap)
+		/* These are kept only when complete */
+		case "metrics":
+			ok = s.decode(&o.Metrics)
+		case "events":
+			ok = s.decode(&o.Events)
+		case "threads":
+			ok = s.decode(&o.Threads)
+		default:
+			return s.skip()
+		}
+		seen[key] = ok
+		return ok
+	})
+
+	o.prepare()
+	if s.err == nil {
+		return &o, nil
+	}
+	if len(o.FileProfileMap) == 0 && len(seen) == 0 {
+		return nil, errors.New("Nothing to salvage: " + s.err.Error())
+	}
+
+	warnings := []string{fmt.Sprintf("The profile is truncated (%v)", s.err)}
+	for _, key := range []string{"start", "stop", "duration"} {
+		if !seen[key] {
+			warnings = append(warnings, fmt.Sprintf("The %s time of the profile is missing", key))
+		}
+	}
+	if !seen["duration"] && seen["start"] && seen["stop"] {
+		o.Duration = o.Stop
+		o.Duration.Subtract(o.Start)
+		warnings = append(warnings, "The duration is taken as the difference between the stop and start times")
+	}
+	warnings = append(warnings, o.Warnings...)
+	warnings = append(warnings, s.warnings...)
+	warnings = append(warnings, fmt.Sprintf("Salvaged %d file(s), %d line record(s) and %d function record(s)",
+		len(o.FileProfileMap), s.lines, s.functions))
+	o.Warnings = warnings
+	return &o, nil
+}
@@ -0,0 +1,69 @@
+package json
+
+import (
+	"strings"
+	"testing"
+)
+
+var salvageProfile = `{
+	"start": { "nsec": 0, "sec": 42 },
+	"stop": { "nsec": 0, "sec": 52 },
+	"files": {
+		"/a": [
+			null,
+			{ "hits": 3, "total_duration": { "nsec": 100, "sec": 0 } }
+		],
+		"/b": [
+			{ "hits": 1, "total_duration": { "nsec": 200, "sec": 0 } },
+			{
+				"functions": [
+					{ "name": "f", "filename": "/b", "start_line": 2, "hits": 1 },
+					{ "name": "g", "filename": "/b", "start_line": 2, "hits": 1 }
+				],
+				"hits": 1,
+				"total_duration": { "nsec": 300, "sec": 0 }
+			}
+		]
+	},
+	"duration": { "nsec": 0, "sec": 10 }
+}`
+
+func TestSalvageCompleteProfile(tt *testing.T) {
+	t = tt
+	p, err := Salvage(strings.NewReader(salvageProfile))
+	logFailIf(err != nil, "Salvage: %v", err)
+	assertEqual(len(p.Warnings), 0, "A complete profile has no warnings")
+	assertEqual(len(p.FileProfileMap["/b"]), 2, "Lines of /b")
+	assertEqual(p.Duration, TimeSpec{10, 0}, "Duration")
+}
+
+func TestSalvageTruncatedProfile(tt *testing.T) {
+	t = tt
+	/* Cut off after the record of function f */
+	cut := strings.Index(salvageProfile, `{ "name": "g"`)
+	p, err := Salvage(strings.NewReader(salvageProfile[:cut]))
+	logFailIf(err != nil, "Salvage: %v", err)
+
+	a := p.FileProfileMap["/a"]
+	assertEqual(len(a), 2, "Lines of the complete file")
+	assertEqual(a[1].Hits, 3, "Hits of a complete line")
+
+	b := p.FileProfileMap["/b"]
+	assertEqual(len(b), 2, "Lines of the truncated file")
+	assertEqual(b[0].Hits, 1, "Hits of a complete line")
+	assertEqual(len(*b[1].Functions), 1, "Function records of the incomplete line")
+	assertEqual((*b[1].Functions)[0].Name, "f", "Salvaged function")
+
+	assertEqual(p.Start, TimeSpec{42, 0}, "Start")
+	assertEqual(p.Duration, TimeSpec{10, 0}, "Duration from stop - start")
+	logFailIf(len(p.Warnings) == 0, "A truncated profile must have warnings")
+	logFailIf(!strings.Contains(p.Warnings[0], "truncated"), "First warning: %s", p.Warnings[0])
+}
+
+func TestSalvageNothing(tt *testing.T) {
+	t = tt
+	_, err := Salvage(strings.NewReader(`{ "sta`))
+	logFailIf(err == nil, "Nothing salvaged must be an error")
+	_, err = Salvage(strings.NewReader(`garbage`))
+	logFailIf(err == nil, "Garbage must be an error")
+}
@@ -0,0 +1,164 @@
+package json
+
+import (
+	"sort"
+	"strconv"
+)
+
+/*
+ * The optional "threads" section of a profile: the records of each thread of
+ * a multi-threaded program, keyed by thread id. The top level "files" stay
+ * the combined records of all threads.
+ */
+type ThreadProfile struct {
+	Name           string      `json:"name"`
+	Start          TimeSpec    `json:"start"`
+	Stop           TimeSpec    `json:"stop"`
+	Duration       TimeSpec    `json:"duration"`
+	FileProfileMap FileProfile `json:"files"`
+}
+
+/* Thread ids in numeric order, or in lexical order when not numbers */
+func (p *Profile) ThreadIds() []string {
+	ids := make([]string, 0, len(p.Threads))
+	for id := range p.Threads {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, errA := strconv.ParseUint(ids[i], 10, 64)
+		b, errB := strconv.ParseUint(ids[j], 10, 64)
+		if errA == nil && errB == nil {
+			return a < b
+		}
+		if (errA == nil) != (errB == nil) {
+			return errA == nil
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+/*
+ * Thread returns the profile of one thread, nil for an unknown id. Times the
+ * thread record lacks are taken from the whole profile.
+ */
+func (p *Profile) Thread(id string) *Profile {
+	t := p.Threads[id]
+	if t == nil {
+		return nil
+	}
+	o := Profile{
+		Start:          t.Start,
+		Stop:           t.Stop,
+		Duration:       t.Duration,
+		FileProfileMap: t.FileProfileMap,
+		Metrics:        p.Metrics,
+		Warnings:       p.Warnings,
+	}
+	if o.FileProfileMap == nil {
+		o.FileProfileMap = FileProfile{}
+	}
+	for _, e := range p.Events {
+		if e.Thread == id {
+			o.Events = append(o.Events, e)
+		}
+	}
+	zero := TimeSpec{}
+	if o.Start == zero {
+		o.Start = p.Start
+	}
+	if o.Stop == zero {
+		o.Stop = p.Stop
+	}
+	if o.Duration == zero {
+		o.Duration = p.Duration
+	}
+	return &o
+}
+
+/*
+ * Builds the combined records from the threads, for profiles that only have
+ * the per-thread section. The thread records are copied, not shared.
+ */
+func (p *Profile) combineThreads() {
+	if len(p.FileProfileMap) > 0 || len(p.Threads) == 0 {
+		return
+	}
+	combined := FileProfile{}
+	for _, id := range p.ThreadIds() {
+		for file, lines := range p.Threads[id].FileProfileMap {
+			target := combined[file]
+			for len(target) < len(lines) {
+				target = append(target, nil)
+			}
+			for i, lp := range lines {
+				if lp == nil {
+					continue
+				}
+				if target[i] == nil {
+					target[i] = &LineProfile{}
+				}
+				target[i].add(lp, p.Metrics)
+			}
+			combined[file] = target
+		}
+	}
+	p.FileProfileMap = combined
+}
+
+func (lp *LineProfile) add(other *LineProfile, declarations []MetricDeclaration) {
+	lp.Hits += other.Hits
+	lp.TotalDuration.Add(other.TotalDuration)
+	lp.Metrics.Add(other.Metrics, declarations)
+	if other.Functions == nil {
+		return
+	}
+	if lp.Functions == nil {
+		lp.Functions = &FunctionProfileSlice{}
+	}
+	for _, f := range *other.Functions {
+		if f == nil {
+			continue
+		}
+		lp.Functions.add(f, declarations)
+	}
+}
+
+/* Adds a function record, merging it with the one of the same function */
+func (functions *FunctionProfileSlice) add(f *FunctionProfile, declarations []MetricDeclaration) {
+	for _, existing := range *functions {
+		if existing.FullName() == f.FullName() && existing.Filename == f.Filename {
+			existing.Hits += f.Hits
+			existing.InclusiveDuration.Add(f.InclusiveDuration)
+			existing.ExclusiveDuration.Add(f.ExclusiveDuration)
+			existing.Metrics.Add(f.Metrics, declarations)
+			for _, c := range f.Callers {
+				existing.Callers = existing.Callers.add(c)
+			}
+			return
+		}
+	}
+	copied := *f
+	copied.Callers = nil
+	copied.Metrics = nil
+	copied.Metrics.Add(f.Metrics, declarations)
+	for _, c := range f.Callers {
+		copied.Callers = copied.Callers.add(c)
+	}
+	*functions = append(*functions, &copied)
+}
+
+func (callers FunctionCallerSlice) add(c *FunctionCaller) FunctionCallerSlice {
+	if c == nil {
+		return callers
+	}
+	for _, existing := range callers {
+		if existing.At == c.At && existing.Filename == c.Filename && existing.FullName() == c.FullName() {
+			existing.Frequency += c.Frequency
+			existing.TotalDuration.Add(c.TotalDuration)
+			return callers
+		}
+	}
+	copied := *c
+	return append(callers, &copied)
+}
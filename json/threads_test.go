@@ -0,0 +1,87 @@
+package json
+
+import (
+	"strings"
+	"testing"
+)
+
+var threadsProfile = `{
+	"start": { "nsec": 0, "sec": 42 },
+	"stop": { "nsec": 0, "sec": 52 },
+	"duration": { "nsec": 0, "sec": 10 },
+	"threads": {
+		"10": {
+			"name": "worker",
+			"duration": { "nsec": 0, "sec": 4 },
+			"files": {
+				"/a": [
+					{
+						"functions": [
+							{ "name": "f", "hits": 2, "inclusive_duration": { "nsec": 500, "sec": 0 },
+							  "callers": [ { "at": 3, "file": "/b", "frequency": 2, "name": "main",
+							                 "total_duration": { "nsec": 500, "sec": 0 } } ] }
+						],
+						"hits": 2,
+						"total_duration": { "nsec": 100, "sec": 0 }
+					}
+				]
+			}
+		},
+		"2": {
+			"files": {
+				"/a": [
+					{
+						"functions": [
+							{ "name": "f", "hits": 1, "inclusive_duration": { "nsec": 300, "sec": 0 },
+							  "callers": [ { "at": 3, "file": "/b", "frequency": 1, "name": "main",
+							                 "total_duration": { "nsec": 300, "sec": 0 } } ] }
+						],
+						"hits": 1,
+						"total_duration": { "nsec": 50, "sec": 0 }
+					},
+					{ "hits": 7, "total_duration": { "nsec": 70, "sec": 0 } }
+				]
+			}
+		}
+	}
+}`
+
+func TestThreadIds(tt *testing.T) {
+	t = tt
+	p := Profile{Threads: map[string]*ThreadProfile{"10": nil, "2": nil, "main": nil, "1": nil}}
+	assertEqual(p.ThreadIds(), []string{"1", "2", "10", "main"}, "Numeric ids first, in numeric order")
+}
+
+func TestThread(tt *testing.T) {
+	t = tt
+	p, err := From(strings.NewReader(threadsProfile))
+	logFailIf(err != nil, "From: %v", err)
+
+	worker := p.Thread("10")
+	assertEqual(worker.Duration, TimeSpec{4, 0}, "Duration of the thread")
+	assertEqual(worker.Start, TimeSpec{42, 0}, "Start falls back to the profile's")
+	assertEqual(len(worker.FileProfileMap["/a"]), 1, "Lines of the thread")
+	logFailIf(p.Thread("3") != nil, "Unknown thread")
+}
+
+func TestCombineThreads(tt *testing.T) {
+	t = tt
+	p, err := From(strings.NewReader(threadsProfile))
+	logFailIf(err != nil, "From: %v", err)
+
+	lines := p.FileProfileMap["/a"]
+	assertEqual(len(lines), 2, "Combined lines")
+	assertEqual(lines[0].Hits, 3, "Combined hits")
+	assertEqual(lines[0].TotalDuration, TimeSpec{0, 150}, "Combined duration")
+	assertEqual(lines[1].Hits, 7, "Line of one thread only")
+
+	functions := *lines[0].Functions
+	assertEqual(len(functions), 1, "Records of the same function are merged")
+	assertEqual(functions[0].Hits, 3, "Combined function hits")
+	assertEqual(functions[0].InclusiveDuration, TimeSpec{0, 800}, "Combined inclusive duration")
+	assertEqual(len(functions[0].Callers), 1, "Same callers are merged")
+	assertEqual(functions[0].Callers[0].Frequency, 3, "Combined caller frequency")
+
+	thread := *p.Threads["10"].FileProfileMap["/a"][0].Functions
+	assertEqual(thread[0].Hits, 2, "Thread records are left alone")
+}
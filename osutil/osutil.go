@@ -42,6 +42,23 @@ func ForEachLineInFile(filename string, sp fileLineHandler) {
 	}
 }
 
+func FileExists(file string) bool {
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return false
+	}
+	return true
+}
+
+/* The spaces and tabs str starts with, to indent notes like the code they are about */
+func LeadingWhiteSpace(str string) string {
+	for i, v := range str {
+		if v != ' ' && v != '\t' {
+			return str[0:i]
+		}
+	}
+	return ""
+}
+
 func CountLine(filename string) int {
 	lineCount := 0
 	increaseLineCount := func(line int, text string) {
@@ -71,3 +88,11 @@ func CreateFiles(files map[string]string) {
 		fmt.Fprint(file, content)
 	}
 }
+
+func IsTerminal(file *os.File) bool {
+	fi, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
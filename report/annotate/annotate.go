@@ -0,0 +1,238 @@
+package annotate
+
+import (
+	"fmt"
+	"fprof/log"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+import "fprof/json"
+import "fprof/osutil"
+import "fprof/report"
+
+/* The metric width matches ferite_profile.c write_profile_line_entry(), without custom metrics */
+const MetricWidth = 62
+
+/*
+ * AnnotateReporter writes plain text copies of the profiled source files with
+ * the line metrics in front of every line and the caller/callee notes of the
+ * html report in between, suitable for grep and less.
+ *
+ * With neither File nor Function set, one <file>.txt per source file is
+ * written under ReportDir/files. Otherwise only the given source file or
+ * function is written to ProfileFile.
+ */
+type AnnotateReporter struct {
+	report.Report
+	File     string
+	Function string
+	metrics  []json.MetricDeclaration
+}
+
+func New(reportDir string, w io.Writer) *AnnotateReporter {
+	r := AnnotateReporter{}
+	r.ReportDir = reportDir
+	r.ProfileFile = w
+	return &r
+}
+
+/*
+ * Writes annotated source to w, with a column for each custom metric after
+ * the ones of ferite_profile.c, at least as wide as its title.
+ */
+type annotation struct {
+	w       io.Writer
+	metrics []json.MetricDeclaration
+	width   int
+}
+
+func newAnnotation(w io.Writer, metrics []json.MetricDeclaration) *annotation {
+	a := &annotation{w, metrics, MetricWidth}
+	for i := range metrics {
+		a.width += 1 + metricColumnWidth(&metrics[i])
+	}
+	return a
+}
+
+func metricColumnWidth(m *json.MetricDeclaration) int {
+	if len(m.Title()) > 10 {
+		return len(m.Title())
+	}
+	return 10
+}
+
+func (a *annotation) lineMetric(lp *json.LineProfile) string {
+	if lp == nil {
+		return ""
+	}
+	metric := fmt.Sprintf("%10v %17v %10v %17v",
+		lp.Hits.EmptyIfZero(),
+		lp.OwnTime().NonZeroMsOrNone(),
+		lp.CallsMade.EmptyIfZero(),
+		lp.TimeInFunctions.NonZeroMsOrNone())
+	for i := range a.metrics {
+		metric += fmt.Sprintf(" %*v", metricColumnWidth(&a.metrics[i]), report.MetricValueOrNone(lp.Metrics, a.metrics[i].Name))
+	}
+	return metric
+}
+
+func (a *annotation) header() string {
+	cth := report.CodeTableHeaders
+	header := fmt.Sprintf("%10v %17v %10v %17v", cth.Hits, cth.TimeOnLine, cth.CallsMade, cth.TimeInFunctions)
+	for i := range a.metrics {
+		header += fmt.Sprintf(" %*v", metricColumnWidth(&a.metrics[i]), a.metrics[i].Title())
+	}
+	return header
+}
+
+func (a *annotation) note(indent, format string, args ...interface{}) {
+	fmt.Fprintf(a.w, "%*v %s// %s\n", a.width, "", indent, fmt.Sprintf(format, args...))
+}
+
+func (a *annotation) writeCallers(fp *json.FunctionProfile, indent string) {
+	freqStr := ":"
+	if fp.Hits > 1 {
+		freqStr = fmt.Sprintf(" %d times:", fp.Hits)
+	}
+	a.note(indent, "Spent %vms within %v() which was called%s", fp.InclusiveDuration.InMillisecondsStr(), fp.FullName(), freqStr)
+
+	diff := fp.Hits - fp.Callers.Total()
+	if diff == 1 {
+		a.note(indent, "once by an unknown caller")
+	} else if diff > 1 {
+		a.note(indent, "%d times by unknown callers, avg %.3fms/call", diff, fp.GetTimeSpentByUnknownCallers().AverageInMilliseconds(diff))
+	}
+	for _, c := range fp.Callers {
+		freqStr = "once"
+		if c.Frequency > 1 {
+			freqStr = fmt.Sprintf("%d times", c.Frequency)
+		}
+		a.note(indent, "%s (%vms) by %s() at %s:%d, avg %.3fms/call",
+			freqStr, c.TotalDuration.InMillisecondsStr(),
+			c.FullName(), c.Filename, c.At,
+			c.TotalDuration.AverageInMilliseconds(c.Frequency))
+	}
+}
+
+func (a *annotation) writeCallsMade(lp *json.LineProfile, indent string) {
+	sort.Stable(lp.FunctionCalls)
+	for _, c := range lp.FunctionCalls {
+		callTxt := "in"
+		avgTxt := ""
+		if c.CallsMade > 1 {
+			callTxt = fmt.Sprintf("making %d calls to", c.CallsMade)
+			avgTxt = fmt.Sprintf(", avg %.3fms/call", c.TimeInFunctions.AverageInMilliseconds(c.CallsMade))
+		}
+		where := ""
+		if path.IsAbs(c.To.Filename) {
+			where = fmt.Sprintf(" defined at %s:%d", c.To.Filename, c.To.StartLine)
+		}
+		a.note(indent, "Spent %vms %s %s()%s%s",
+			c.TimeInFunctions.InMillisecondsStr(), callTxt, c.To.FullName(), where, avgTxt)
+	}
+}
+
+func (a *annotation) writeLine(lp *json.LineProfile, text string) {
+	indent := osutil.LeadingWhiteSpace(text)
+	if lp != nil && lp.Functions != nil {
+		for _, f := range *lp.Functions {
+			a.writeCallers(f, indent)
+		}
+	}
+	fmt.Fprintf(a.w, "%*v %v\n", a.width, a.lineMetric(lp), text)
+	if lp != nil {
+		a.writeCallsMade(lp, indent)
+	}
+}
+
+/* Writes lines [from, to] of file, to < 1 meaning up to the end of file */
+func (a *annotation) writeFile(file string, lineProfiles []*json.LineProfile, from, to int) {
+	fmt.Fprintf(a.w, "%*v %v\n", a.width, a.header(), file)
+	inRange := func(line int) bool {
+		return line >= from && (to < 1 || line <= to)
+	}
+	lastLine := 0
+	osutil.ForEachLineInFile(file, func(line int, text string) {
+		lastLine = line
+		if !inRange(line) {
+			return
+		}
+		var lp *json.LineProfile
+		if line <= len(lineProfiles) {
+			lp = lineProfiles[line-1]
+		}
+		a.writeLine(lp, text)
+	})
+	/* The profile may know of more lines than the file has now */
+	for line := lastLine + 1; line <= len(lineProfiles); line++ {
+		if inRange(line) {
+			a.writeLine(lineProfiles[line-1], "")
+		}
+	}
+}
+
+func (r *AnnotateReporter) writeFiles(fileProfiles json.FileProfile) {
+	filePrefix := r.ReportDir + "/" + report.FilesDir
+	for _, file := range report.SortedFilenames(fileProfiles) {
+		if !osutil.FileExists(file) {
+			log.Printf("Skipped (file does not exist): %s\n", file)
+			continue
+		}
+		txtFile := filePrefix + "/" + file + ".txt"
+		osutil.CreateDir(path.Dir(txtFile))
+		out, err := os.Create(txtFile)
+		if err != nil {
+			log.Fatal(txtFile, ":", err)
+		}
+		newAnnotation(out, r.metrics).writeFile(file, fileProfiles[file], 1, 0)
+		out.Close()
+	}
+}
+
+func (r *AnnotateReporter) writeFunction(fileProfiles json.FileProfile, functions json.FunctionProfileSlice) {
+	found := false
+	for _, f := range functions {
+		if f == nil || (f.FullName() != r.Function && f.Name != r.Function) {
+			continue
+		}
+		found = true
+		if !osutil.FileExists(f.Filename) {
+			log.Printf("Source of %s() not found: %s\n", f.FullName(), f.Filename)
+			continue
+		}
+		first, last := report.GetFunctionExtent(fileProfiles, f)
+		newAnnotation(r.ProfileFile, r.metrics).writeFile(f.Filename, fileProfiles[f.Filename], first, last)
+	}
+	if !found {
+		log.Fatal("No function named ", r.Function, " in the profile")
+	}
+}
+
+func (r *AnnotateReporter) ReportFunctions(p *json.Profile) {
+	fileProfiles := p.FileProfileMap
+	functions := fileProfiles.GetFunctionsSortedByExlusiveTime()
+	r.metrics = p.Metrics
+
+	switch {
+	case r.Function != "":
+		r.writeFunction(fileProfiles, functions)
+	case r.File != "":
+		file := r.File
+		if _, ok := fileProfiles[file]; !ok {
+			/* The profile keeps absolute paths */
+			if abs, err := filepath.Abs(file); err == nil {
+				file = abs
+			}
+		}
+		if !osutil.FileExists(file) {
+			log.Fatal("File not found: ", r.File)
+		}
+		newAnnotation(r.ProfileFile, r.metrics).writeFile(file, fileProfiles[file], 1, 0)
+	default:
+		r.writeFiles(fileProfiles)
+	}
+}
@@ -0,0 +1,153 @@
+package annotate
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+import "fprof/json"
+
+func writeSource(t *testing.T) string {
+	file := filepath.Join(t.TempDir(), "a.fe")
+	source := "function f() {\n\treturn 1;\n}\nf();\n"
+	if err := os.WriteFile(file, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func profileFor(file string) *json.Profile {
+	return json.DecodeFromBytes([]byte(`{
+	"files": {
+		"` + file + `": [
+			{
+				"functions": [
+					{
+						"name": "f",
+						"start_line": 1,
+						"exclusive_duration": { "sec": 0, "nsec": 0 },
+						"inclusive_duration": { "sec": 0, "nsec": 3000000 },
+						"hits": 1,
+						"callers": [
+							{ "at": 4, "file": "` + file + `", "frequency": 1, "name": "main",
+							  "total_duration": { "sec": 0, "nsec": 3000000 } }
+						]
+					}
+				],
+				"hits": 1,
+				"total_duration": { "sec": 0, "nsec": 1000000 }
+			},
+			{ "hits": 1, "total_duration": { "sec": 0, "nsec": 2000000 } },
+			null,
+			{ "hits": 1, "total_duration": { "sec": 0, "nsec": 3500000 } }
+		]
+	}
+}`))
+}
+
+func TestAnnotateFile(t *testing.T) {
+	file := writeSource(t)
+	var b bytes.Buffer
+	r := New("", &b)
+	r.File = file
+	r.ReportFunctions(profileFor(file))
+
+	lines := strings.Split(b.String(), "\n")
+	expected := []string{
+		"// Spent 3.000ms within f() which was called:",
+		"// once (3.000ms) by main() at " + file + ":4, avg 3.000ms/call",
+		"function f() {",
+		"\treturn 1;",
+		"}",
+		"f();",
+		"// Spent 3.000ms in f() defined at " + file + ":1",
+	}
+	for i, e := range expected {
+		got := lines[i+1]
+		if len(got) < MetricWidth || strings.TrimLeft(got[MetricWidth:], " ") != e {
+			t.Errorf("Line %d: expected %q, got %q", i+1, e, got)
+		}
+	}
+	if got := strings.Fields(lines[6][:MetricWidth]); strings.Join(got, " ") != "1 0.500 1 3.000" {
+		t.Errorf("Expected the metrics of line 4, got %q", got)
+	}
+}
+
+func TestAnnotateFunction(t *testing.T) {
+	file := writeSource(t)
+	var b bytes.Buffer
+	r := New("", &b)
+	r.Function = "f"
+	r.ReportFunctions(profileFor(file))
+	if !strings.Contains(b.String(), "function f() {") {
+		t.Errorf("Expected the source of f():\n%s", b.String())
+	}
+}
+
+/* The same lines as the function page of the html report, see report.GetFunctionExtent */
+func TestAnnotateFunctionExtent(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "a.fe")
+	source := "function f() {\n\treturn g();\n}\nfunction g() {\n\treturn 1;\n}\n"
+	if err := os.WriteFile(file, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := json.DecodeFromBytes([]byte(`{"files":{"` + file + `":[
+		{"functions":[{"name":"f","start_line":1,"hits":1}],"hits":1},
+		{"hits":1},
+		null,
+		{"functions":[{"name":"g","start_line":4,"hits":1}],"hits":1},
+		{"hits":1}]}}`))
+	for name, want := range map[string]string{"f": "function f() {|\treturn g();|}", "g": "function g() {|\treturn 1;"} {
+		var b bytes.Buffer
+		r := New("", &b)
+		r.Function = name
+		r.ReportFunctions(p)
+		code := []string{}
+		for _, line := range strings.Split(strings.TrimRight(b.String(), "\n"), "\n")[1:] {
+			if text := strings.TrimLeft(line[MetricWidth:], " "); !strings.HasPrefix(text, "//") {
+				code = append(code, text)
+			}
+		}
+		if got := strings.Join(code, "|"); got != want {
+			t.Errorf("%s(): got lines %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestAnnotateMetrics(t *testing.T) {
+	file := writeSource(t)
+	var b bytes.Buffer
+	r := New("", &b)
+	r.File = file
+	r.ReportFunctions(json.DecodeFromBytes([]byte(`{
+		"metrics": [ { "name": "allocated", "unit": "bytes" } ],
+		"files": { "` + file + `": [
+			{ "functions": [ { "name": "f", "start_line": 1, "hits": 1 } ], "hits": 1 },
+			{ "hits": 1, "metrics": { "allocated": 4096 } }
+		] }
+	}`)))
+	lines := strings.Split(b.String(), "\n")
+	width := MetricWidth + 1 + len("allocated (bytes)")
+	if !strings.HasSuffix(lines[0][:width], " allocated (bytes)") {
+		t.Errorf("Expected an allocated column in %q", lines[0])
+	}
+	/* Line 2 of the source follows the two notes about f() and line 1 */
+	if got := lines[4]; !strings.HasSuffix(got[:width], " 4096") || got[width+1:] != "\treturn 1;" {
+		t.Errorf("Expected 4096 allocated on line 2, got %q", got)
+	}
+	if got := lines[1]; !strings.HasPrefix(got[width+1:], "// Spent") {
+		t.Errorf("Expected the notes after the metrics, got %q", got)
+	}
+}
+
+func TestWriteFiles(t *testing.T) {
+	file := writeSource(t)
+	dir := t.TempDir()
+	New(dir, nil).ReportFunctions(profileFor(file))
+	if _, err := os.Stat(dir + "/files/" + file + ".txt"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,124 @@
+package report
+
+import (
+	"fprof/json"
+	"sort"
+)
+
+/*
+ * CallGraph is the caller->callee graph of a profile. Nodes are keyed by the
+ * function's full name since that is all a FunctionCaller knows about the
+ * calling function.
+ */
+type CallGraph struct {
+	Nodes map[string]*CallGraphNode
+	Edges []*CallGraphEdge
+}
+
+type CallGraphNode struct {
+	Name string
+	/* nil when the function only shows up as a caller */
+	Function  *json.FunctionProfile
+	Self      json.TimeSpec
+	Inclusive json.TimeSpec
+	Calls     json.Counter
+	In        []*CallGraphEdge
+	Out       []*CallGraphEdge
+}
+
+type CallGraphEdge struct {
+	From  *CallGraphNode
+	To    *CallGraphNode
+	Calls json.Counter
+	Time  json.TimeSpec
+}
+
+func (g *CallGraph) node(name string) *CallGraphNode {
+	n := g.Nodes[name]
+	if n == nil {
+		n = &CallGraphNode{Name: name}
+		g.Nodes[name] = n
+	}
+	return n
+}
+
+func NewCallGraph(functions json.FunctionProfileSlice) *CallGraph {
+	g := &CallGraph{Nodes: make(map[string]*CallGraphNode)}
+	edges := make(map[[2]string]*CallGraphEdge)
+
+	for _, f := range functions {
+		if f == nil {
+			continue
+		}
+		callee := g.node(f.FullName())
+		if callee.Function == nil {
+			callee.Function = f
+		}
+		callee.Self.Add(f.OwnTime)
+		callee.Inclusive.Add(f.InclusiveDuration)
+		callee.Calls += f.Hits
+
+		for _, c := range f.Callers {
+			if c == nil {
+				continue
+			}
+			key := [2]string{c.FullName(), callee.Name}
+			e := edges[key]
+			if e == nil {
+				caller := g.node(key[0])
+				e = &CallGraphEdge{From: caller, To: callee}
+				edges[key] = e
+				caller.Out = append(caller.Out, e)
+				callee.In = append(callee.In, e)
+				g.Edges = append(g.Edges, e)
+			}
+			e.Calls += c.Frequency
+			e.Time.Add(c.TotalDuration)
+		}
+	}
+	sort.Stable(CallGraphEdgeSlice(g.Edges))
+	for _, n := range g.Nodes {
+		if n.Function == nil {
+			/* Best we know of a caller-only function is what it spent calling */
+			for _, e := range n.Out {
+				n.Inclusive.Add(e.Time)
+			}
+		}
+		sort.Stable(CallGraphEdgeSlice(n.In))
+		sort.Stable(CallGraphEdgeSlice(n.Out))
+	}
+	return g
+}
+
+/* SortedNodes returns the nodes by descending inclusive time, then by name */
+func (g *CallGraph) SortedNodes() CallGraphNodeSlice {
+	nodes := make(CallGraphNodeSlice, 0, len(g.Nodes))
+	for _, n := range g.Nodes {
+		nodes = append(nodes, n)
+	}
+	sort.Sort(nodes)
+	return nodes
+}
+
+type CallGraphNodeSlice []*CallGraphNode
+
+func (p CallGraphNodeSlice) Len() int { return len(p) }
+func (p CallGraphNodeSlice) Less(i, j int) bool {
+	if p[j].Inclusive.IsLessThan(&p[i].Inclusive) {
+		return true
+	}
+	if p[i].Inclusive.IsLessThan(&p[j].Inclusive) {
+		return false
+	}
+	return p[i].Name < p[j].Name
+}
+func (p CallGraphNodeSlice) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+/* Sorts descending by time */
+type CallGraphEdgeSlice []*CallGraphEdge
+
+func (p CallGraphEdgeSlice) Len() int { return len(p) }
+func (p CallGraphEdgeSlice) Less(j, i int) bool {
+	return p[i].Time.IsLessThan(&p[j].Time)
+}
+func (p CallGraphEdgeSlice) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
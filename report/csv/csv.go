@@ -0,0 +1,128 @@
+package csv
+
+import (
+	"encoding/csv"
+	"fprof/log"
+	"io"
+	"strconv"
+)
+
+import "fprof/json"
+import "fprof/report"
+
+const (
+	FunctionsTable = "functions"
+	LinesTable     = "lines"
+)
+
+/*
+ * CsvReporter writes the functions table or the per line metrics as comma
+ * (or tab) separated values. Times are written as plain milliseconds so
+ * spreadsheets treat them as numbers.
+ */
+type CsvReporter struct {
+	w     *csv.Writer
+	Table string
+}
+
+func New(w io.Writer, comma rune) *CsvReporter {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	return &CsvReporter{cw, FunctionsTable}
+}
+
+func ms(ts json.TimeSpec) string {
+	return strconv.FormatFloat(ts.InMilliseconds(), 'f', -1, 64)
+}
+
+func count(n json.Counter) string {
+	return strconv.FormatUint(uint64(n), 10)
+}
+
+func (r *CsvReporter) write(record ...string) {
+	if err := r.w.Write(record); err != nil {
+		log.Fatal(err)
+	}
+}
+
+/* The custom metrics of the profile go in extra columns at the end */
+func metricTitles(metrics []json.MetricDeclaration) []string {
+	titles := []string{}
+	for i := range metrics {
+		titles = append(titles, metrics[i].Title())
+	}
+	return titles
+}
+
+func metricValues(metrics []json.MetricDeclaration, values json.Metrics) []string {
+	record := []string{}
+	for _, m := range metrics {
+		record = append(record, report.MetricValueOrNone(values, m.Name))
+	}
+	return record
+}
+
+func (r *CsvReporter) writeFunctions(functions json.FunctionProfileSlice, metrics []json.MetricDeclaration) {
+	fth := report.FunctionTableHeaders
+	header := []string{fth.Calls, fth.Places, fth.Files, fth.SelfMs, fth.InclusiveMs, fth.Ratio,
+		"Function", "Namespace", "File", "Start line", "Native"}
+	r.write(append(header, metricTitles(metrics)...)...)
+	for _, f := range functions {
+		if f == nil {
+			continue
+		}
+		record := []string{
+			count(f.Hits),
+			strconv.Itoa(f.CountCallingPlaces()),
+			strconv.Itoa(f.CountCallingFiles()),
+			ms(f.OwnTime),
+			ms(f.InclusiveDuration),
+			strconv.FormatFloat(f.OwnTimeRatio(), 'f', 1, 64),
+			f.Name,
+			f.NameSpace,
+			f.Filename,
+			count(f.StartLine),
+			strconv.FormatBool(f.IsNative),
+		}
+		r.write(append(record, metricValues(metrics, f.Metrics)...)...)
+	}
+}
+
+func (r *CsvReporter) writeLines(fileProfiles json.FileProfile, metrics []json.MetricDeclaration) {
+	cth := report.CodeTableHeaders
+	header := []string{"File", cth.Line, cth.Hits, cth.TimeOnLine, cth.CallsMade, cth.TimeInFunctions}
+	r.write(append(header, metricTitles(metrics)...)...)
+	for _, file := range report.SortedFilenames(fileProfiles) {
+		for i, lp := range fileProfiles[file] {
+			if lp == nil {
+				continue
+			}
+			record := []string{
+				file,
+				strconv.Itoa(i + 1),
+				count(lp.Hits),
+				ms(lp.OwnTime()),
+				count(lp.CallsMade),
+				ms(lp.TimeInFunctions),
+			}
+			r.write(append(record, metricValues(metrics, lp.Metrics)...)...)
+		}
+	}
+}
+
+func (r *CsvReporter) ReportFunctions(p *json.Profile) {
+	/* Needed for the line metrics too, it fills in the calls made */
+	functions := p.FileProfileMap.GetFunctionsSortedByExlusiveTime()
+	switch r.Table {
+	case FunctionsTable:
+		r.writeFunctions(functions, p.Metrics)
+	case LinesTable:
+		r.writeLines(p.FileProfileMap, p.Metrics)
+	default:
+		log.Fatal("Unknown table ", r.Table)
+	}
+	r.w.Flush()
+	if err := r.w.Error(); err != nil {
+		log.Fatal(err)
+	}
+}
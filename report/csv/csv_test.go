@@ -0,0 +1,79 @@
+package csv
+
+import (
+	"bytes"
+	"testing"
+)
+
+import "fprof/json"
+
+var profileJson = []byte(`{
+	"files": {
+		"/a.fe": [
+			null,
+			{
+				"functions": [
+					{
+						"name": "f",
+						"namespace": "NS",
+						"start_line": 2,
+						"exclusive_duration": { "sec": 0, "nsec": 500000 },
+						"inclusive_duration": { "sec": 0, "nsec": 2000000 },
+						"hits": 3,
+						"callers": [
+							{ "at": 3, "file": "/a.fe", "frequency": 3, "name": "main",
+							  "total_duration": { "sec": 0, "nsec": 2000000 } }
+						]
+					}
+				],
+				"hits": 3,
+				"total_duration": { "sec": 0, "nsec": 1000 }
+			},
+			{ "hits": 1, "total_duration": { "sec": 0, "nsec": 2250000 } }
+		]
+	}
+}`)
+
+func TestFunctionsTable(t *testing.T) {
+	var b bytes.Buffer
+	New(&b, ',').ReportFunctions(json.DecodeFromBytes(profileJson))
+	expected := "Calls,Places,Files,Self (ms),Inclusive (ms),Incl/Excl %,Function,Namespace,File,Start line,Native\n" +
+		"3,1,1,1.5,2,75.0,f,NS,/a.fe,2,false\n"
+	if b.String() != expected {
+		t.Errorf("Got:\n%s\nExpected:\n%s", b.String(), expected)
+	}
+}
+
+func TestLinesTable(t *testing.T) {
+	var b bytes.Buffer
+	r := New(&b, '\t')
+	r.Table = LinesTable
+	r.ReportFunctions(json.DecodeFromBytes(profileJson))
+	expected := "File\tLine\tHits\tTime on line (ms)\tCalls Made\tTime in functions\n" +
+		"/a.fe\t2\t3\t0.001\t0\t0\n" +
+		"/a.fe\t3\t1\t0.25\t3\t2\n"
+	if b.String() != expected {
+		t.Errorf("Got:\n%s\nExpected:\n%s", b.String(), expected)
+	}
+}
+
+func TestMetricColumns(t *testing.T) {
+	var b bytes.Buffer
+	r := New(&b, ',')
+	r.Table = LinesTable
+	r.ReportFunctions(json.DecodeFromBytes([]byte(`{
+		"metrics": [ { "name": "allocated", "unit": "bytes" }, { "name": "syscalls" } ],
+		"files": {
+			"/a.fe": [
+				{ "hits": 1, "metrics": { "allocated": 1024 } },
+				{ "hits": 2, "metrics": { "allocated": 0, "syscalls": 3 } }
+			]
+		}
+	}`)))
+	expected := "File,Line,Hits,Time on line (ms),Calls Made,Time in functions,allocated (bytes),syscalls\n" +
+		"/a.fe,1,1,0,0,0,1024,\n" +
+		"/a.fe,2,2,0,0,0,0,3\n"
+	if b.String() != expected {
+		t.Errorf("Got:\n%s\nExpected:\n%s", b.String(), expected)
+	}
+}
@@ -0,0 +1,96 @@
+package dot
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+import "fprof/json"
+import "fprof/report"
+
+/*
+ * DotReporter writes the caller->callee graph of a profile in graphviz DOT
+ * format. Like pprof's nodefraction/edgefraction, nodes whose inclusive time
+ * and edges whose time fall below the given fraction of the profile duration
+ * are left out.
+ */
+type DotReporter struct {
+	w            io.Writer
+	NodeFraction float64
+	EdgeFraction float64
+}
+
+func New(w io.Writer) *DotReporter {
+	return &DotReporter{w, 0.005, 0.001}
+}
+
+func quote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	return `"` + s + `"`
+}
+
+func share(ms, totalMs float64) string {
+	if totalMs <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", ms*100/totalMs)
+}
+
+func (r *DotReporter) ReportFunctions(p *json.Profile) {
+	functions := p.FileProfileMap.GetFunctionsSortedByExlusiveTime()
+	ownTimeStat, _ := report.GetMADStats(functions)
+	graph := report.NewCallGraph(functions)
+
+	totalMs := p.Duration.InMilliseconds()
+	nodeCutoff := totalMs * r.NodeFraction
+	edgeCutoff := totalMs * r.EdgeFraction
+
+	ids := make(map[*report.CallGraphNode]string)
+	droppedNodes := 0
+	for _, n := range graph.SortedNodes() {
+		if n.Inclusive.InMilliseconds() < nodeCutoff {
+			droppedNodes++
+			continue
+		}
+		ids[n] = fmt.Sprintf("n%d", len(ids)+1)
+	}
+	droppedEdges := 0
+	edges := []*report.CallGraphEdge{}
+	for _, e := range graph.Edges {
+		if ids[e.From] == "" || ids[e.To] == "" || e.Time.InMilliseconds() < edgeCutoff {
+			droppedEdges++
+			continue
+		}
+		edges = append(edges, e)
+	}
+
+	fmt.Fprintln(r.w, "digraph fprof {")
+	fmt.Fprintf(r.w, "\tgraph [label=%s, labelloc=t];\n", quote(fmt.Sprintf(
+		"Duration: %sms\nDropped %d nodes (inclusive < %.3fms)\nDropped %d edges (time < %.3fms)",
+		p.Duration.InMillisecondsStr(), droppedNodes, nodeCutoff, droppedEdges, edgeCutoff)))
+	fmt.Fprintln(r.w, "\tnode [shape=box, style=filled, fontname=sans];")
+	fmt.Fprintln(r.w, "\tedge [fontname=sans];")
+
+	for _, n := range graph.SortedNodes() {
+		id := ids[n]
+		if id == "" {
+			continue
+		}
+		selfMs, inclMs := n.Self.InMilliseconds(), n.Inclusive.InMilliseconds()
+		label := fmt.Sprintf("%s\nself %.3fms (%s)\ninclusive %.3fms (%s)",
+			n.Name, selfMs, share(selfMs, totalMs), inclMs, share(inclMs, totalMs))
+		if n.Calls > 0 {
+			label += fmt.Sprintf("\n%d calls", n.Calls)
+		}
+		color := report.SeverityColors[report.GetSeverityClass(selfMs, ownTimeStat)]
+		fmt.Fprintf(r.w, "\t%s [label=%s, fillcolor=%s];\n", id, quote(label), color)
+	}
+	for _, e := range edges {
+		label := fmt.Sprintf("%d calls\n%.3fms", e.Calls, e.Time.InMilliseconds())
+		fmt.Fprintf(r.w, "\t%s -> %s [label=%s];\n", ids[e.From], ids[e.To], quote(label))
+	}
+	fmt.Fprintln(r.w, "}")
+}
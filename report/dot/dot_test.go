@@ -0,0 +1,77 @@
+package dot
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+import "fprof/json"
+
+var profileJson = []byte(`{
+	"duration": { "sec": 0, "nsec": 100000000 },
+	"files": {
+		"/a.fe": [
+			{
+				"functions": [
+					{
+						"name": "slow",
+						"start_line": 1,
+						"exclusive_duration": { "sec": 0, "nsec": 10000000 },
+						"inclusive_duration": { "sec": 0, "nsec": 90000000 },
+						"hits": 2,
+						"callers": [
+							{ "at": 2, "file": "/a.fe", "frequency": 2, "name": "main",
+							  "total_duration": { "sec": 0, "nsec": 90000000 } }
+						]
+					},
+					{
+						"name": "tiny",
+						"start_line": 1,
+						"exclusive_duration": { "sec": 0, "nsec": 0 },
+						"inclusive_duration": { "sec": 0, "nsec": 1000 },
+						"hits": 1,
+						"callers": [
+							{ "at": 2, "file": "/a.fe", "frequency": 1, "name": "slow",
+							  "total_duration": { "sec": 0, "nsec": 1000 } }
+						]
+					}
+				],
+				"hits": 1,
+				"total_duration": { "sec": 0, "nsec": 1000 }
+			},
+			{ "hits": 1, "total_duration": { "sec": 0, "nsec": 1000 } }
+		]
+	}
+}`)
+
+func TestReportFunctions(t *testing.T) {
+	p := json.DecodeFromBytes(profileJson)
+	var b bytes.Buffer
+	New(&b).ReportFunctions(p)
+	out := b.String()
+
+	expected := []string{
+		"digraph fprof {",
+		`label="main\nself 0.000ms (0.0%)\ninclusive 90.000ms (90.0%)"`,
+		`label="slow\nself 80.000ms (80.0%)\ninclusive 90.000ms (90.0%)\n2 calls"`,
+		`n1 -> n2 [label="2 calls\n90.000ms"];`,
+		"Dropped 1 nodes",
+	}
+	for _, e := range expected {
+		if !strings.Contains(out, e) {
+			t.Errorf("Expected %q in output:\n%s", e, out)
+		}
+	}
+	if strings.Contains(out, "tiny") {
+		t.Errorf("Node below NodeFraction must be pruned:\n%s", out)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	got := quote("a\"b\\c\nd")
+	expected := `"a\"b\\c\nd"`
+	if got != expected {
+		t.Errorf("quote() got %s, expected %s", got, expected)
+	}
+}
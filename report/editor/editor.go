@@ -0,0 +1,137 @@
+/*
+Package editor writes profile results for text editors: a quickfix list of
+the hot lines for vim, emacs or VS Code problem matchers, and a JSON file of
+the metrics of every profiled line for gutter heat marks.
+*/
+package editor
+
+import (
+	stdjson "encoding/json"
+	"fmt"
+	"fprof/log"
+	"io"
+	"sort"
+	"strings"
+)
+
+import "fprof/json"
+import "fprof/report"
+
+/*
+ * QuickfixReporter prints one file:line:col: message line per profiled
+ * line that took any time, the costliest first counting the time in the
+ * functions called from it. The message starts with the severity of the
+ * time on the line itself, e.g.
+ *
+ *	/app/a.fe:12:1: bad 12.345ms self, 40 hits, calls Obj.f(), g()
+ */
+type QuickfixReporter struct {
+	w io.Writer
+}
+
+func NewQuickfix(w io.Writer) *QuickfixReporter {
+	return &QuickfixReporter{w}
+}
+
+func severityName(class string) string {
+	return strings.TrimPrefix(class, "s_")
+}
+
+func callees(lp *json.LineProfile) string {
+	sort.Stable(lp.FunctionCalls)
+	names := make([]string, 0, len(lp.FunctionCalls))
+	for _, c := range lp.FunctionCalls {
+		names = append(names, c.To.FullName()+"()")
+	}
+	return strings.Join(names, ", ")
+}
+
+func (r *QuickfixReporter) ReportFunctions(p *json.Profile) {
+	/* Fills in the calls made by the lines, see callees() */
+	p.FileProfileMap.GetFunctionsSortedByExlusiveTime()
+	lines := report.GetLines(p.FileProfileMap)
+	sort.Stable(report.LineRefsByTotalTime(lines))
+	for _, l := range lines {
+		lp := l.Profile
+		ownTime := lp.OwnTime()
+		if ownTime.InNanoseconds() <= 0 && lp.TimeInFunctions.InNanoseconds() <= 0 {
+			continue
+		}
+		fmt.Fprintf(r.w, "%s:%d:1: %s %sms self, %d hits", l.File, l.Line,
+			severityName(l.Severity), ownTime.InMillisecondsStr(), lp.Hits)
+		if len(lp.FunctionCalls) > 0 {
+			fmt.Fprintf(r.w, ", %sms in calls to %s", lp.TimeInFunctions.InMillisecondsStr(), callees(lp))
+		}
+		fmt.Fprintln(r.w)
+	}
+}
+
+const LineMetricsVersion = 1
+
+type LineMetric struct {
+	Line              int      `json:"line"`
+	Hits              uint64   `json:"hits"`
+	SelfNs            int64    `json:"self_ns"`
+	CallsMade         uint64   `json:"calls_made"`
+	TimeInFunctionsNs int64    `json:"time_in_functions_ns"`
+	Severity          string   `json:"severity"`
+	Calls             []string `json:"calls,omitempty"`
+	/* The custom metrics declared by the profile */
+	Metrics json.Metrics `json:"metrics,omitempty"`
+}
+
+type LineMetrics struct {
+	Version    int                     `json:"version"`
+	DurationNs int64                   `json:"duration_ns"`
+	Files      map[string][]LineMetric `json:"files"`
+}
+
+/*
+ * LineMetricsReporter writes the metrics of every profiled line, grouped by
+ * file and ordered by line number, as JSON. Severity is one of low, medium,
+ * high or bad, judged against the other lines of the same file.
+ */
+type LineMetricsReporter struct {
+	w io.Writer
+}
+
+func NewLineMetrics(w io.Writer) *LineMetricsReporter {
+	return &LineMetricsReporter{w}
+}
+
+func (r *LineMetricsReporter) ReportFunctions(p *json.Profile) {
+	/* Fills in the calls made by the lines */
+	p.FileProfileMap.GetFunctionsSortedByExlusiveTime()
+	metrics := LineMetrics{LineMetricsVersion, p.Duration.InNanoseconds(), make(map[string][]LineMetric)}
+	for _, file := range report.SortedFilenames(p.FileProfileMap) {
+		lineProfiles := p.FileProfileMap[file]
+		ownTimeStats, _ := report.GetLineMADStats(lineProfiles)
+		lines := []LineMetric{}
+		for i, lp := range lineProfiles {
+			if lp == nil {
+				continue
+			}
+			ownTime := lp.OwnTime()
+			m := LineMetric{
+				Line:              i + 1,
+				Hits:              uint64(lp.Hits),
+				SelfNs:            ownTime.InNanoseconds(),
+				CallsMade:         uint64(lp.CallsMade),
+				TimeInFunctionsNs: lp.TimeInFunctions.InNanoseconds(),
+				Severity:          severityName(report.GetSeverityClass(ownTime.InMilliseconds(), ownTimeStats)),
+				Metrics:           lp.Metrics,
+			}
+			sort.Stable(lp.FunctionCalls)
+			for _, c := range lp.FunctionCalls {
+				m.Calls = append(m.Calls, c.To.FullName())
+			}
+			lines = append(lines, m)
+		}
+		metrics.Files[file] = lines
+	}
+
+	encoder := stdjson.NewEncoder(r.w)
+	if err := encoder.Encode(metrics); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,78 @@
+package editor
+
+import (
+	"bytes"
+	stdjson "encoding/json"
+	"testing"
+)
+
+import "fprof/json"
+
+var profileJson = []byte(`{
+	"files": {
+		"/a.fe": [
+			{
+				"functions": [
+					{
+						"name": "f",
+						"namespace": "Obj",
+						"start_line": 1,
+						"inclusive_duration": { "sec": 0, "nsec": 2000000 },
+						"hits": 1,
+						"callers": [
+							{ "at": 3, "file": "/a.fe", "frequency": 1, "name": "main",
+							  "total_duration": { "sec": 0, "nsec": 2000000 } }
+						]
+					}
+				],
+				"hits": 1,
+				"total_duration": { "sec": 0, "nsec": 1000000 }
+			},
+			null,
+			{ "hits": 40, "total_duration": { "sec": 0, "nsec": 14345000 } },
+			{ "hits": 1, "total_duration": { "sec": 0, "nsec": 0 } }
+		]
+	}
+}`)
+
+func TestQuickfix(t *testing.T) {
+	var b bytes.Buffer
+	NewQuickfix(&b).ReportFunctions(json.DecodeFromBytes(profileJson))
+	expected := "/a.fe:3:1: low 12.345ms self, 40 hits, 2.000ms in calls to Obj.f()\n" +
+		"/a.fe:1:1: low 1.000ms self, 1 hits\n"
+	if b.String() != expected {
+		t.Errorf("Got:\n%s\nExpected:\n%s", b.String(), expected)
+	}
+}
+
+/* A line costs the time in the functions it calls as well */
+func TestQuickfixOrder(t *testing.T) {
+	var b bytes.Buffer
+	NewQuickfix(&b).ReportFunctions(json.DecodeFromBytes([]byte(`{"files":{"/a.fe":[
+		{"functions":[{"name":"f","start_line":1,"hits":1,"inclusive_duration":{"sec":0,"nsec":10000000},
+			"callers":[{"at":2,"file":"/a.fe","frequency":1,"name":"main","total_duration":{"sec":0,"nsec":10000000}}]}]},
+		{"hits":1,"total_duration":{"sec":0,"nsec":11000000}},
+		{"hits":1,"total_duration":{"sec":0,"nsec":5000000}}]}}`)))
+	expected := "/a.fe:2:1: low 1.000ms self, 1 hits, 10.000ms in calls to f()\n" +
+		"/a.fe:3:1: low 5.000ms self, 1 hits\n"
+	if b.String() != expected {
+		t.Errorf("Got:\n%s\nExpected:\n%s", b.String(), expected)
+	}
+}
+
+func TestLineMetrics(t *testing.T) {
+	var b bytes.Buffer
+	NewLineMetrics(&b).ReportFunctions(json.DecodeFromBytes(profileJson))
+	var m LineMetrics
+	if err := stdjson.Unmarshal(b.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	lines := m.Files["/a.fe"]
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 lines, got %+v", lines)
+	}
+	l := lines[1]
+	if l.Line != 3 || l.SelfNs != 12345000 || l.CallsMade != 1 || len(l.Calls) != 1 || l.Calls[0] != "Obj.f" {
+		t.Errorf("Unexpected line metric %+v", l)
+	}
+}
@@ -0,0 +1,73 @@
+package report
+
+import (
+	"sort"
+)
+
+import "fprof/json"
+
+/*
+ * GetFunctionExtent tells the lines of a function, 1-based and inclusive.
+ * Profiles only record where functions start, so a function is taken to end
+ * where the next function of its file starts, or at its last profiled line.
+ */
+func GetFunctionExtent(fileProfiles json.FileProfile, f *json.FunctionProfile) (int, int) {
+	lines := fileProfiles[f.Filename]
+	first := int(f.StartLine)
+	if first < 1 {
+		first = 1
+	}
+	for i := first; i < len(lines); i++ {
+		lp := lines[i]
+		if lp != nil && lp.Functions != nil && len(*lp.Functions) > 0 {
+			return first, i
+		}
+	}
+	if len(lines) < first {
+		return first, first
+	}
+	return first, len(lines)
+}
+
+/* A function called from within another one */
+type Callee struct {
+	Function *json.FunctionProfile
+	Calls    json.Counter
+	Time     json.TimeSpec
+}
+
+type CalleeSlice []*Callee
+
+/* Sorted descending by time */
+func (p CalleeSlice) Len() int           { return len(p) }
+func (p CalleeSlice) Less(i, j int) bool { return p[j].Time.IsLessThan(&p[i].Time) }
+func (p CalleeSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+/*
+ * GetCallees sums up the calls made on the lines of a function by the called
+ * function, the most time consuming first. The FunctionCalls of the lines
+ * must have been filled in, see GetFunctionsSortedByExlusiveTime.
+ */
+func GetCallees(fileProfiles json.FileProfile, f *json.FunctionProfile) CalleeSlice {
+	first, last := GetFunctionExtent(fileProfiles, f)
+	lines := fileProfiles[f.Filename]
+	byFunction := make(map[*json.FunctionProfile]*Callee)
+	callees := CalleeSlice{}
+	for i := first - 1; i < last && i < len(lines); i++ {
+		if lines[i] == nil {
+			continue
+		}
+		for _, c := range lines[i].FunctionCalls {
+			callee := byFunction[c.To]
+			if callee == nil {
+				callee = &Callee{Function: c.To}
+				byFunction[c.To] = callee
+				callees = append(callees, callee)
+			}
+			callee.Calls += c.CallsMade
+			callee.Time.Add(c.TimeInFunctions)
+		}
+	}
+	sort.Stable(callees)
+	return callees
+}
@@ -0,0 +1,232 @@
+package html
+
+import (
+	stdjson "encoding/json"
+	"html/template"
+	"net/url"
+)
+
+import "fprof/json"
+import "fprof/log"
+import "fprof/report"
+
+/* The levels of callers and callees the call graph shows at first */
+var CallGraphLevels = 2
+
+/* The most levels the call graph can be asked to show */
+var CallGraphMaxLevels = 6
+
+/*
+ * The call graph page draws the neighbourhood of one function as SVG, the
+ * function named by the URL fragment, else the one of the most inclusive
+ * time. Columns left of it hold the callers, right of it the callees, the
+ * heaviest ones first.
+ */
+const callGraphJs = `var callgraphMaxColumn = 12;
+var svgNs = 'http://www.w3.org/2000/svg';
+function callgraphData() {
+	var el = document.getElementById('fprof_callgraph');
+	if (!el.fprofGraph) {
+		var g = JSON.parse(el.textContent);
+		g.index = {};
+		g.callers = [];
+		g.callees = [];
+		for (var i = 0; i < g.nodes.length; i++) {
+			g.index[g.nodes[i][0]] = i;
+			g.callers.push([]);
+			g.callees.push([]);
+		}
+		/* Edges come heaviest first */
+		g.edges.forEach(function(e) {
+			g.callees[e[0]].push(e);
+			g.callers[e[1]].push(e);
+		});
+		el.fprofGraph = g;
+	}
+	return el.fprofGraph;
+}
+function svgElement(tag, attrs, parent) {
+	var el = document.createElementNS(svgNs, tag);
+	for (var name in attrs) el.setAttribute(name, attrs[name]);
+	if (parent) parent.appendChild(el);
+	return el;
+}
+function svgTitle(el, text) {
+	svgElement('title', {}, el).textContent = text;
+}
+/* The columns of nodes, by level from -levels (callers) to levels (callees) */
+function callgraphColumns(g, centre, levels) {
+	var level = {};
+	level[centre] = 0;
+	var columns = {0: {nodes: [centre], more: 0}};
+	[[-1, g.callers, 0], [1, g.callees, 1]].forEach(function(dir) {
+		var frontier = [centre];
+		for (var l = 1; l <= levels; l++) {
+			var column = {nodes: [], more: 0}, seen = {};
+			frontier.forEach(function(n) {
+				dir[1][n].forEach(function(e) {
+					var other = e[dir[2]];
+					if (level.hasOwnProperty(other) || seen[other]) return;
+					seen[other] = true;
+					if (column.nodes.length < callgraphMaxColumn) {
+						level[other] = l * dir[0];
+						column.nodes.push(other);
+					} else {
+						column.more++;
+					}
+				});
+			});
+			columns[l * dir[0]] = column;
+			frontier = column.nodes;
+		}
+	});
+	return {level: level, columns: columns};
+}
+function callgraphLabel(name) {
+	return name.length > 26 ? name.substring(0, 25) + '…' : name;
+}
+function drawCallgraph() {
+	var g = callgraphData();
+	var view = document.getElementById('callgraph');
+	$(view).empty();
+	$('#callgraph_title').text('');
+	$('#callgraph_links').empty();
+	if (!g.nodes.length) {
+		$(view).text('No calls to show');
+		return;
+	}
+	var centre = g.index[fprofAnchor()];
+	if (centre === undefined) centre = g.index[g.centre];
+	var levels = parseInt($('#callgraph_levels').val(), 10) || 1;
+	var layout = callgraphColumns(g, centre, levels);
+	var colWidth = 230, nodeWidth = 180, nodeHeight = 24, rowHeight = 34;
+	var rows = 1;
+	for (var l in layout.columns) {
+		var column = layout.columns[l];
+		rows = Math.max(rows, column.nodes.length + (column.more ? 1 : 0));
+	}
+	var height = rows * rowHeight + 10;
+	var svg = svgElement('svg', {width: (2 * levels + 1) * colWidth, height: height}, view);
+	var edges = svgElement('g', {}, svg);
+	var at = {};
+	for (var l in layout.columns) {
+		var column = layout.columns[l];
+		var x = (parseInt(l, 10) + levels) * colWidth + (colWidth - nodeWidth) / 2;
+		var top = (height - (column.nodes.length + (column.more ? 1 : 0)) * rowHeight) / 2;
+		column.nodes.forEach(function(n, i) {
+			var node = g.nodes[n], y = top + i * rowHeight;
+			at[n] = [x, y];
+			var el = svgElement('g', {'class': n == centre ? 'node centre' : 'node', 'data-name': node[0]}, svg);
+			svgElement('rect', {x: x, y: y, width: nodeWidth, height: nodeHeight, rx: 4, 'class': node[2]}, el);
+			svgElement('text', {x: x + 6, y: y + 16}, el).textContent = callgraphLabel(node[0]);
+			svgTitle(el, node[0] + '\n' + node[5] + ' calls, ' + node[3] + 'ms inclusive, ' + node[4] + 'ms self');
+		});
+		if (column.more) {
+			svgElement('text', {x: x + 6, y: top + column.nodes.length * rowHeight + 16, 'class': 'more'}, svg).textContent = '+' + column.more + ' more';
+		}
+	}
+	/* Only the calls from one column into the next */
+	var drawn = g.edges.filter(function(e) {
+		return at[e[0]] && at[e[1]] && layout.level[e[1]] == layout.level[e[0]] + 1;
+	});
+	var heaviest = 0;
+	drawn.forEach(function(e) { heaviest = Math.max(heaviest, e[3]); });
+	drawn.forEach(function(e) {
+		var from = at[e[0]], to = at[e[1]];
+		var x1 = from[0] + nodeWidth, y1 = from[1] + nodeHeight / 2, x2 = to[0], y2 = to[1] + nodeHeight / 2;
+		var mid = (x1 + x2) / 2;
+		var path = svgElement('path', {
+			d: 'M' + x1 + ',' + y1 + ' C' + mid + ',' + y1 + ' ' + mid + ',' + y2 + ' ' + x2 + ',' + y2,
+			'stroke-width': 1 + (heaviest > 0 ? 9 * e[3] / heaviest : 0)
+		}, edges);
+		svgTitle(path, g.nodes[e[0]][0] + ' → ' + g.nodes[e[1]][0] + '\n' + e[2] + ' calls, ' + e[3].toFixed(3) + 'ms');
+	});
+
+	var node = g.nodes[centre];
+	$('#callgraph_title').text(node[0]);
+	if (node[1]) {
+		var a = document.createElement('a');
+		a.setAttribute('href', node[1]);
+		a.textContent = 'Function details';
+		$('#callgraph_links').append(a);
+	}
+}
+$(document).on('click', '#callgraph g.node', function() {
+	fprofGo('#' + encodeURIComponent($(this).attr('data-name')));
+});
+$(document).on('change', '#callgraph_levels', function() {
+	drawCallgraph();
+});
+$(window).on('hashchange', function() {
+	if ($('#callgraph').length) drawCallgraph();
+});
+function initCallgraph() {
+	drawCallgraph();
+}
+$(document).ready(function() {
+	if ($('#callgraph').length) initCallgraph();
+});
+`
+
+/* What callgraph.js draws from, see report.CallGraph */
+type callGraphData struct {
+	/* The function shown when the page URL names none */
+	Centre string `json:"centre"`
+	/* [name, function page, severity class of the self time, inclusive ms, self ms, calls] */
+	Nodes [][]interface{} `json:"nodes"`
+	/* [caller index in Nodes, callee index, calls, ms] */
+	Edges [][]interface{} `json:"edges"`
+}
+
+/* The href of the call graph centred on the function name, from the report root */
+func callGraphHref(name string) string {
+	return "callgraph.html#" + url.PathEscape(name)
+}
+
+func (r *HtmlReporter) newCallGraphData(functionCalls json.FunctionProfileSlice) *callGraphData {
+	g := report.NewCallGraph(functionCalls)
+	/* Coloured by self time like the functions table and the DOT graph */
+	ownTimeStat, _ := report.GetMADStats(functionCalls)
+	data := &callGraphData{Nodes: [][]interface{}{}, Edges: [][]interface{}{}}
+	index := make(map[*report.CallGraphNode]int)
+	for i, n := range g.SortedNodes() {
+		if i == 0 {
+			data.Centre = n.Name
+		}
+		index[n] = i
+		f := n.Function
+		if f == nil {
+			if candidates := r.functionPages.byName[n.Name]; len(candidates) > 0 {
+				f = candidates[0]
+			}
+		}
+		page := ""
+		if f != nil {
+			page = urlPath(r.functionPages.pages[f])
+		}
+		data.Nodes = append(data.Nodes, []interface{}{n.Name, page,
+			report.GetSeverityClass(n.Self.InMilliseconds(), ownTimeStat),
+			n.Inclusive.InMillisecondsStr(), n.Self.InMillisecondsStr(), n.Calls})
+	}
+	for _, e := range g.Edges {
+		data.Edges = append(data.Edges, []interface{}{index[e.From], index[e.To], e.Calls, e.Time.InMilliseconds()})
+	}
+	return data
+}
+
+/* Writes callgraph.html, with the graph data in the page */
+func (r *HtmlReporter) GenerateCallGraphHtmlFile(p *json.Profile, jsFiles []string, functionCalls json.FunctionProfileSlice) {
+	graph, err := stdjson.Marshal(r.newCallGraphData(functionCalls))
+	if err != nil {
+		log.Fatal("Encoding the call graph: ", err)
+	}
+	data := CallGraphPage{
+		Page: newPage("Call graph", "", append(append([]string{}, jsFiles...), "js/callgraph.js"), p.Warnings),
+		/* Marshal escapes <, > and &, so the data can not end its element */
+		Data: template.JS(graph),
+	}
+	for i := 1; i <= CallGraphMaxLevels; i++ {
+		data.Levels = append(data.Levels, CallGraphLevel{i, i == CallGraphLevels})
+	}
+	r.writePage("callgraph.html", "callgraph.html", &data)
+}
@@ -0,0 +1,246 @@
+package html
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+import "fprof/json"
+import "fprof/osutil"
+import "fprof/report"
+import "fprof/stats"
+
+const FunctionsDir = "functions"
+
+/* Source lines beyond this many are left out of a function page */
+var FunctionExcerptMaxLines = 500
+
+/*
+ * Names the detail page of every function and finds the function of a
+ * caller, which is only known by name and the file the call was made in.
+ */
+type functionPages struct {
+	pages  map[*json.FunctionProfile]string
+	byName map[string][]*json.FunctionProfile
+}
+
+func pageName(name string) string {
+	return strings.Map(func(ch rune) rune {
+		if ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '-' || ch == '_' || ch == '.' {
+			return ch
+		}
+		return '_'
+	}, name)
+}
+
+func newFunctionPages(functions json.FunctionProfileSlice) *functionPages {
+	fp := &functionPages{
+		pages:  make(map[*json.FunctionProfile]string),
+		byName: make(map[string][]*json.FunctionProfile),
+	}
+	used := make(map[string]bool)
+	for _, f := range functions {
+		if f == nil {
+			continue
+		}
+		base := pageName(f.FullName())
+		page := base
+		for n := 2; used[strings.ToLower(page)]; n++ {
+			page = fmt.Sprintf("%s-%d", base, n)
+		}
+		/* Lower case, not to clash on case insensitive file systems */
+		used[strings.ToLower(page)] = true
+		fp.pages[f] = FunctionsDir + "/" + page + ".html"
+		fp.byName[f.FullName()] = append(fp.byName[f.FullName()], f)
+	}
+	return fp
+}
+
+/* The function of a caller, nil when it did not get profiled */
+func (fp *functionPages) caller(c *json.FunctionCaller) *json.FunctionProfile {
+	candidates := fp.byName[c.FullName()]
+	for _, f := range candidates {
+		if f.Filename == c.Filename {
+			return f
+		}
+	}
+	if len(candidates) > 0 {
+		return candidates[0]
+	}
+	return nil
+}
+
+/* A link to the detail page of f, from a page toRoot away from the report root */
+func (fp *functionPages) link(f *json.FunctionProfile, text, toRoot string) Link {
+	page, ok := fp.pages[f]
+	if !ok {
+		return Link{Text: text}
+	}
+	return Link{Href: toRoot + page, Text: text}
+}
+
+func share(t, total json.TimeSpec) string {
+	if total.InNanoseconds() <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.1f%%", float64(t.InNanoseconds())*100/float64(total.InNanoseconds()))
+}
+
+/* A link to a line of a source page, from a function page */
+func (r *HtmlReporter) functionPageSourceLink(text, file string, line json.Counter, exists map[string]bool) Link {
+	if !exists[file] || isEval(file) {
+		return Link{Text: text}
+	}
+	return Link{Href: fmt.Sprintf("../%s#%d", urlPath(path.Clean(r.htmlLineFilename(file))), line), Text: text}
+}
+
+func (r *HtmlReporter) functionSummary(data *FunctionPage, p *json.Profile, f *json.FunctionProfile) {
+	data.SummaryHeaders = append([]string{fth.Calls, fth.Places, fth.Files, fth.SelfMs, fth.InclusiveMs, fth.Ratio, "Self % of duration", "Inclusive % of duration"}, r.metricHeaders()...)
+	data.Summary = []Cell{
+		titledCell(fth.Calls, f.Hits),
+		titledCell(fth.Places, f.CountCallingPlaces()),
+		titledCell(fth.Files, f.CountCallingFiles()),
+		titledCell(fth.SelfMs, f.OwnTime.InMillisecondsStr()),
+		titledCell(fth.InclusiveMs, f.InclusiveDuration.InMillisecondsStr()),
+		titledCell(fth.Ratio, fmt.Sprintf("%3.1f", f.OwnTimeRatio())),
+		titledCell("Self % of duration", share(f.OwnTime, p.Duration)),
+		titledCell("Inclusive % of duration", share(f.InclusiveDuration, p.Duration)),
+	}
+	for i := range r.metrics {
+		data.Summary = append(data.Summary, titledCell(r.metrics[i].Title(), report.MetricValueOrNone(f.Metrics, r.metrics[i].Name)))
+	}
+}
+
+func callCells(calls json.Counter, t json.TimeSpec) []Cell {
+	return []Cell{{Value: fmt.Sprint(calls)}, {Value: t.InMillisecondsStr()}, {Value: fmt.Sprintf("%.3f", t.AverageInMilliseconds(calls))}}
+}
+
+func (r *HtmlReporter) functionCallers(data *FunctionPage, f *json.FunctionProfile, exists map[string]bool) {
+	for _, c := range f.Callers {
+		if c == nil {
+			continue
+		}
+		row := CallerRow{
+			Cells:    callCells(c.Frequency, c.TotalDuration),
+			Function: Link{Text: c.FullName()},
+			At:       r.functionPageSourceLink(fmt.Sprintf("%s:%d", c.Filename, c.At), c.Filename, c.At, exists),
+		}
+		if caller := r.functionPages.caller(c); caller != nil {
+			row.Function = r.functionPages.link(caller, c.FullName(), "../")
+		}
+		data.Callers = append(data.Callers, row)
+	}
+	if unknown := f.Hits - f.Callers.Total(); f.Hits > f.Callers.Total() {
+		data.UnknownCallers = callCells(unknown, *f.GetTimeSpentByUnknownCallers())
+	}
+}
+
+func (r *HtmlReporter) functionCallees(data *FunctionPage, fileProfiles json.FileProfile, f *json.FunctionProfile) {
+	for _, c := range report.GetCallees(fileProfiles, f) {
+		data.Callees = append(data.Callees, CalleeRow{
+			Cells:    callCells(c.Calls, c.Time),
+			Function: r.functionPages.link(c.Function, c.Function.FullName(), "../"),
+		})
+	}
+}
+
+/* A source file read once for the pages of all functions it defines */
+type functionSourceFile struct {
+	lines                        []string
+	lineProfiles                 []*json.LineProfile
+	ownTimeStats, otherTimeStats *stats.Stats
+	metricStats                  []*stats.Stats
+}
+
+/* The source file with the stats of its lines, nil if it is not to be shown */
+func (r *HtmlReporter) newFunctionSourceFile(fileProfiles json.FileProfile, file string, exists map[string]bool) *functionSourceFile {
+	if !exists[file] || isEval(file) {
+		return nil
+	}
+	sf := &functionSourceFile{lineProfiles: fileProfiles[file]}
+	osutil.ForEachLineInFile(file, func(lineNo int, text string) {
+		sf.lines = append(sf.lines, text)
+	})
+	sf.ownTimeStats, sf.otherTimeStats = report.GetLineMADStats(sf.lineProfiles)
+	sf.metricStats = report.GetLineMetricStats(sf.lineProfiles, r.metrics)
+	return sf
+}
+
+/* The source of the function with the metrics of its lines */
+func (r *HtmlReporter) functionSource(data *FunctionPage, fileProfiles json.FileProfile, f *json.FunctionProfile, sf *functionSourceFile, exists map[string]bool) {
+	if sf == nil {
+		return
+	}
+	first, last := report.GetFunctionExtent(fileProfiles, f)
+	truncated := false
+	if last-first+1 > FunctionExcerptMaxLines {
+		last = first + FunctionExcerptMaxLines - 1
+		truncated = true
+	}
+
+	data.SourceHeaders = r.lineHeaders()
+	/* A function does not start within a comment, so the excerpt highlights on its own */
+	hl := &highlighter{}
+	for lineNo := first; lineNo <= last && lineNo <= len(sf.lines); lineNo++ {
+		if lineNo < 1 {
+			continue
+		}
+		text := sf.lines[lineNo-1]
+		var lp *json.LineProfile
+		if lineNo <= len(sf.lineProfiles) {
+			lp = sf.lineProfiles[lineNo-1]
+		}
+		data.Source = append(data.Source, SourceLine{
+			No:    lineNo,
+			Link:  r.functionPageSourceLink(fmt.Sprint(lineNo), f.Filename, json.Counter(lineNo), exists),
+			Cells: r.lineCells(lp, sf.ownTimeStats, sf.otherTimeStats, sf.metricStats),
+			Code:  text,
+			Spans: hl.line(text),
+		})
+	}
+	if truncated {
+		more := r.functionPageSourceLink("More lines ...", f.Filename, json.Counter(last+1), exists)
+		data.More = &more
+	}
+}
+
+/* Writes the detail page of every function into FunctionsDir */
+func (r *HtmlReporter) GenerateFunctionHtmlFiles(p *json.Profile, jsFiles []string, exists map[string]bool, functionCalls json.FunctionProfileSlice) {
+	if r.single == nil {
+		osutil.CreateDir(r.PathTo(FunctionsDir))
+	}
+	/* Pages are written file by file, each file read once for all its functions */
+	files := []string{}
+	byFile := make(map[string]json.FunctionProfileSlice)
+	for _, f := range functionCalls {
+		if f == nil {
+			continue
+		}
+		if _, ok := byFile[f.Filename]; !ok {
+			files = append(files, f.Filename)
+		}
+		byFile[f.Filename] = append(byFile[f.Filename], f)
+	}
+	for _, file := range files {
+		sf := r.newFunctionSourceFile(p.FileProfileMap, file, exists)
+		for _, f := range byFile[file] {
+			r.generateFunctionHtmlFile(p, jsFiles, exists, f, sf)
+		}
+	}
+}
+
+/* Writes the detail page of f, sf being its source file */
+func (r *HtmlReporter) generateFunctionHtmlFile(p *json.Profile, jsFiles []string, exists map[string]bool, f *json.FunctionProfile, sf *functionSourceFile) {
+	data := FunctionPage{
+		Page:      newPage(f.FullName(), "../", jsFiles, nil),
+		Name:      f.FullName(),
+		DefinedAt: r.functionPageSourceLink(fmt.Sprintf("%s:%d", f.Filename, f.StartLine), f.Filename, f.StartLine, exists),
+		CallGraph: Link{Href: "../" + callGraphHref(f.FullName()), Text: "Call graph"},
+	}
+	r.functionSummary(&data, p, f)
+	r.functionCallers(&data, f, exists)
+	r.functionCallees(&data, p.FileProfileMap, f)
+	r.functionSource(&data, p.FileProfileMap, f, sf, exists)
+	r.writePage("function.html", r.functionPages.pages[f], &data)
+}
@@ -0,0 +1,144 @@
+package html
+
+import (
+	"strings"
+)
+
+/* Source files longer than this many lines are shown without highlighting */
+var HighlightMaxLines = 50000
+
+/* The classes of highlighted ferite tokens */
+const (
+	hlKeyword = "kw"
+	hlString  = "str"
+	hlComment = "com"
+	hlNumber  = "num"
+	/* Namespaces and classes */
+	hlType = "typ"
+)
+
+var feriteKeywords = map[string]bool{}
+
+/* Declaring one of these makes the name that follows a namespace or class */
+var feriteTypeKeywords = map[string]bool{
+	"namespace": true, "class": true, "protocol": true, "extends": true,
+	"implements": true, "modifies": true, "new": true, "isa": true, "instanceof": true,
+}
+
+func init() {
+	for _, k := range strings.Fields(`uses namespace class protocol extends implements modifies
+		function global final static native abstract private protected public atomic
+		if else while for foreach do switch case default break continue return
+		iferr fix fail raise monitor handle deliver recipient closure
+		new self super null true false isa instanceof eval include arguments
+		number string array object void boolean var and or not`) {
+		feriteKeywords[k] = true
+	}
+}
+
+/* A piece of a source line, plain text without Class */
+type Span struct {
+	Class, Text string
+}
+
+/*
+ * Splits ferite source into Spans line by line, keeping what runs across
+ * lines: block comments, and a name due after a namespace or class keyword.
+ */
+type highlighter struct {
+	inComment bool
+	typeNext  bool
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+/* The spans of the next line, adjacent plain text is kept in one span */
+func (h *highlighter) line(text string) []Span {
+	spans := []Span{}
+	add := func(class, s string) {
+		if n := len(spans); n > 0 && spans[n-1].Class == class {
+			spans[n-1].Text += s
+		} else {
+			spans = append(spans, Span{class, s})
+		}
+	}
+	i := 0
+	for i < len(text) {
+		start := i
+		c := text[i]
+		switch {
+		case h.inComment:
+			if end := strings.Index(text[i:], "*/"); end >= 0 {
+				i += end + 2
+				h.inComment = false
+			} else {
+				i = len(text)
+			}
+			add(hlComment, text[start:i])
+		case c == '/' && strings.HasPrefix(text[i:], "/*"):
+			h.inComment = true
+			i += 2
+			if end := strings.Index(text[i:], "*/"); end >= 0 {
+				i += end + 2
+				h.inComment = false
+			} else {
+				i = len(text)
+			}
+			add(hlComment, text[start:i])
+		case c == '/' && strings.HasPrefix(text[i:], "//"), c == '#' && i == 0 && strings.HasPrefix(text, "#!"):
+			i = len(text)
+			add(hlComment, text[start:])
+		case c == '"' || c == '\'':
+			for i++; i < len(text) && text[i] != c; i++ {
+				if text[i] == '\\' {
+					i++
+				}
+			}
+			if i < len(text) {
+				i++
+			} else {
+				i = len(text)
+			}
+			add(hlString, text[start:i])
+		case isDigit(c) || c == '.' && i+1 < len(text) && isDigit(text[i+1]):
+			for i++; i < len(text) && (isIdentPart(text[i]) || text[i] == '.' ||
+				(text[i] == '+' || text[i] == '-') && (text[i-1] == 'e' || text[i-1] == 'E')); i++ {
+			}
+			add(hlNumber, text[start:i])
+		case isIdentStart(c):
+			for i++; i < len(text) && isIdentPart(text[i]); i++ {
+			}
+			word := text[start:i]
+			switch {
+			case feriteKeywords[word]:
+				add(hlKeyword, word)
+				h.typeNext = feriteTypeKeywords[word]
+				continue
+			case h.typeNext, c >= 'A' && c <= 'Z':
+				add(hlType, word)
+			default:
+				add("", word)
+			}
+			h.typeNext = false
+		default:
+			for i++; i < len(text) && !isIdentStart(text[i]) && !isDigit(text[i]) && !strings.ContainsRune("/\"'.", rune(text[i])); i++ {
+			}
+			plain := text[start:i]
+			if strings.TrimSpace(plain) != "" {
+				h.typeNext = false
+			}
+			add("", plain)
+		}
+	}
+	return spans
+}
@@ -2,11 +2,10 @@ package html
 
 import (
 	"bufio"
-	"bytes"
 	"fmt"
 	"fprof/log"
-	"html"
-	"io"
+	"html/template"
+	"net/url"
 	"os"
 	"path"
 	"sort"
@@ -19,188 +18,24 @@ import "fprof/osutil"
 import "fprof/stats"
 import "fprof/json"
 
-var SEVERITY_LOW = .5
-var SEVERITY_MEDIUM = 1.0
-var SEVERITY_HIGH = 2.0
-
+/*
+ * HtmlReporter writes a browsable report into ReportDir. Its pages are
+ * rendered from the templates of templates.go, which those of the Templates
+ * dir, when given, override.
+ */
 type HtmlReporter struct {
 	report.Report
+	Templates     string
+	templates     *template.Template
+	threads       *threadSelector
+	metrics       []json.MetricDeclaration
+	functionPages *functionPages
+	/* Collects the pages of a single file report, see single.go */
+	single *singleFile
+	/* Path of the report in the single file one, "" or ending in "/" */
+	prefix string
 }
 
-type HtmlWriter struct {
-	SourceFile   string
-	HtmlFilename string
-	realw        io.Writer
-	indent       int
-	w            *bytes.Buffer
-}
-
-func NewHtmlWriter(sourceFile, htmlfile string) *HtmlWriter {
-	hw := HtmlWriter{sourceFile,
-		htmlfile,
-		osutil.CreateFile(htmlfile),
-		0,
-		new(bytes.Buffer),
-	}
-	return &hw
-}
-
-func (hw *HtmlWriter) HiderLink(indent string, nHidden int) {
-	hw.write(`<div class="toggleHide">`)
-	hw.write(indent)
-	hw.write(fmt.Sprintf(`<a href="javascript:" onclick="toggleHide();return false;">Show %d more ...</a>`, nHidden))
-	hw.write(`</div>`)
-	hw.write(`<div class="hide">`)
-}
-
-func (hw *HtmlWriter) writeToDiskAsync(done chan bool) {
-	work := func() {
-		fmt.Fprint(hw.realw, hw.w.String())
-	}
-	if done == nil {
-		work()
-	} else {
-		go func() {
-			work()
-			done <- true
-		}()
-	}
-}
-
-func (hw *HtmlWriter) spaces() {
-	str := ""
-	for i := 0; i < hw.indent; i++ {
-		str += " "
-	}
-	fmt.Fprint(hw.w, str)
-}
-
-func (hw *HtmlWriter) write(v interface{}) {
-	fmt.Fprintf(hw.w, "%v", v)
-}
-
-func (hw *HtmlWriter) writeln(v interface{}) {
-	hw.spaces()
-	fmt.Fprintf(hw.w, "%v\n", v)
-}
-
-func getFirstWhiteSpaces(str string) string {
-
-	for i, v := range str {
-		if v != ' ' && v != '\t' {
-			return str[0:i]
-		}
-	}
-	return ""
-}
-
-func (hw *HtmlWriter) commentln(indent, format string, args ...interface{}) {
-	hw.comment(indent, format+"\n", args...)
-}
-
-func (hw *HtmlWriter) comment(indent, format string, args ...interface{}) {
-	comment := fmt.Sprintf(format, args...)
-	fmt.Fprintf(hw.w, `<div class="profile_note">%s// %s</div>`, indent, comment)
-}
-
-func (hw *HtmlWriter) begin(el string, attrs ...string) {
-	hw.spaces()
-	hw.write("<" + el)
-	for _, v := range attrs {
-		hw.write(" " + v)
-	}
-	hw.write(">")
-	hw.indent++
-}
-
-func (hw *HtmlWriter) beginln(el string, attrs ...string) {
-	hw.begin(el, attrs...)
-	fmt.Fprintln(hw.w, "")
-}
-
-func (hw *HtmlWriter) end(el string) {
-	hw.indent--
-	hw.writeln("</" + el + ">")
-}
-
-func (hw *HtmlWriter) Html(v ...interface{}) {
-	for _, e := range v {
-		hw.write(e)
-	}
-}
-
-func (hw *HtmlWriter) in(el string, v interface{}) {
-	hw.spaces()
-	hw.Html("<", el, ">")
-	hw.Html(v)
-	hw.Html("</", el, ">\n")
-}
-
-func (hw *HtmlWriter) repeatIn(el string, items ...interface{}) {
-	for _, v := range items {
-		hw.in(el, v)
-	}
-}
-
-func (hw *HtmlWriter) HtmlOpen()  { hw.beginln("html") }
-func (hw *HtmlWriter) HtmlClose() { hw.end("html") }
-func (hw *HtmlWriter) HeadOpen()  { hw.beginln("head") }
-func (hw *HtmlWriter) LinkJs(jsFile string) {
-	hw.spaces()
-	hw.Html(fmt.Sprintf(`<script src="%s" type="text/javascript"></script>`+"\n", jsFile))
-}
-func (hw *HtmlWriter) LinkCss(cssFile string) {
-	hw.spaces()
-	hw.Html(fmt.Sprintf(`<link rel="stylesheet" type="text/css" href="%s">`+"\n", cssFile))
-}
-func (hw *HtmlWriter) HeadClose()                { hw.end("head") }
-func (hw *HtmlWriter) BodyOpen()                 { hw.beginln("body") }
-func (hw *HtmlWriter) BodyClose()                { hw.end("body") }
-func (hw *HtmlWriter) TableOpen(attrs ...string) { hw.beginln("table", attrs...) }
-func (hw *HtmlWriter) TableClose()               { hw.end("table") }
-func (hw *HtmlWriter) TheadOpen()                { hw.beginln("thead") }
-func (hw *HtmlWriter) TheadClose()               { hw.end("thead") }
-func (hw *HtmlWriter) TbodyOpen()                { hw.beginln("tbody") }
-func (hw *HtmlWriter) TbodyClose()               { hw.end("tbody") }
-func (hw *HtmlWriter) TrOpen()                   { hw.beginln("tr") }
-func (hw *HtmlWriter) TrClose()                  { hw.end("tr") }
-func (hw *HtmlWriter) ThOpen(attrs ...string)    { hw.beginln("th", attrs...) }
-func (hw *HtmlWriter) ThClose()                  { hw.end("th") }
-func (hw *HtmlWriter) TdOpen(attrs ...string)    { hw.begin("td", attrs...) }
-func (hw *HtmlWriter) TdClose()                  { hw.end("td") }
-func (hw *HtmlWriter) TdTitled(title string, content interface{}) {
-	hw.begin("td", `title="`+title+`"`)
-	hw.write(content)
-	hw.TdCloseNoIndent()
-}
-func (hw *HtmlWriter) TdCloseNoIndent() {
-	hw.Html("</td>\n")
-	hw.indent--
-}
-func (hw *HtmlWriter) TdTitledWithClassOrEmpty(title, class, content string) {
-	if len(content) == 0 {
-		hw.Td("")
-		return
-	}
-
-	if len(title) > 0 {
-		hw.TdOpen(`class="`+class+`"`, `title="`+title+`"`)
-	} else {
-		hw.TdOpen(`class="` + class + `"`)
-	}
-	hw.write(content)
-	hw.TdCloseNoIndent()
-}
-func (hw *HtmlWriter) TdWithClassOrEmpty(class, content string) {
-	hw.TdTitledWithClassOrEmpty("", class, content)
-}
-func (hw *HtmlWriter) DivOpen(attrs ...string) { hw.begin("div", attrs...) }
-func (hw *HtmlWriter) DivClose()               { hw.end("div") }
-func (hw *HtmlWriter) Th(v ...interface{})     { hw.repeatIn("th", v...) }
-func (hw *HtmlWriter) Td(v ...interface{})     { hw.repeatIn("td", v...) }
-func (hw *HtmlWriter) Tr(v ...interface{})     { hw.repeatIn("tr", v...) }
-func (hw *HtmlWriter) Div(v ...interface{})    { hw.repeatIn("div", v...) }
-
 func New(reportDir string) *HtmlReporter {
 	osutil.CreateDir(reportDir)
 	r := HtmlReporter{}
@@ -226,11 +61,26 @@ func isEval(f string) bool {
 	return false
 }
 
-func htmlLink(fromFile, funcName, toFile string, lineNo json.Counter) string {
+/* A link from page, relative to the report root, to a line of toFile */
+func htmlLink(fromPage, text, toFile string, lineNo json.Counter) *Link {
 	if isEval(toFile) {
-		return `<span title="Called from eval()"><i>` + funcName + `</i></span>`
+		return &Link{Text: text, Eval: true}
+	}
+	return &Link{Href: fmt.Sprintf("%s#%d", urlPath(getRelativePathTo(toFile, fromPage)), lineNo), Text: text}
+}
+
+/* Escapes a relative file path for use as URL, file names may have any of "#?%:" */
+func urlPath(p string) string {
+	segments := strings.Split(p, "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	p = strings.Join(segments, "/")
+	/* Not to be taken for a scheme */
+	if i := strings.IndexAny(p, ":/"); i >= 0 && p[i] == ':' {
+		p = "./" + p
 	}
-	return fmt.Sprintf(`<a href="%s#%d">%s</a>`, getRelativePathTo(toFile, fromFile), lineNo, funcName)
+	return p
 }
 
 func pathToRoot(file string) string {
@@ -287,8 +137,10 @@ func getRelativePathTo(to, from string) string {
 	return r
 }
 
-func (r *HtmlReporter) showCallers(hw *HtmlWriter, fp *json.FunctionProfile, indent string) {
+/* The notes on who called fp, for a line of page */
+func (r *HtmlReporter) showCallers(fp *json.FunctionProfile, indent, page string) *NoteList {
 	hideThreshold := 10
+	notes := &NoteList{Indent: indent}
 
 	freqStr := ":"
 	nCalls := fp.Callers.Total()
@@ -304,23 +156,17 @@ func (r *HtmlReporter) showCallers(hw *HtmlWriter, fp *json.FunctionProfile, ind
 	if fp.Hits > 1 {
 		freqStr = fmt.Sprintf(" %d times:", fp.Hits)
 	}
-	hw.comment(indent, "Spent %vms within %v() which was called%s", fp.InclusiveDuration.InMillisecondsStr(), fp.FullName(), freqStr)
+	notes.add(false, Note{Text: fmt.Sprintf("Spent %vms within %v() which was called%s", fp.InclusiveDuration.InMillisecondsStr(), fp.FullName(), freqStr)})
 	if diff > 0 {
-		hw.commentln(indent, "%s", nilCallerStr)
-	}
-	calleeFile := fp.Filename
-	if path.IsAbs(calleeFile) {
-		calleeFile = r.htmlLineFilename(calleeFile)
+		notes.add(false, Note{Text: nilCallerStr})
 	}
 	startHideAt := 0
 	if len(fp.Callers) > hideThreshold {
 		startHideAt = 5
 	}
 	for i, c := range fp.Callers {
-		if startHideAt > 0 {
-			if i == startHideAt {
-				hw.HiderLink(indent, len(fp.Callers)-startHideAt)
-			}
+		if c == nil {
+			continue
 		}
 		callerFile, callerAt := c.Filename, c.At
 		if path.IsAbs(callerFile) {
@@ -330,168 +176,115 @@ func (r *HtmlReporter) showCallers(hw *HtmlWriter, fp *json.FunctionProfile, ind
 		if c.Frequency > 1 {
 			freqStr = fmt.Sprintf("%d times", c.Frequency)
 		}
-		hw.commentln(indent, "%s (%vms) by %s() at %s, avg %.3fms/call",
-			freqStr, c.TotalDuration.InMillisecondsStr(),
-			c.FullName(),
-			htmlLink(calleeFile, fmt.Sprintf("line %d", callerAt), callerFile, callerAt),
-			c.TotalDuration.AverageInMilliseconds(c.Frequency))
-	}
-	if startHideAt > 0 {
-		hw.Html(`</div>`)
+		notes.add(startHideAt > 0 && i >= startHideAt, Note{
+			Text:  fmt.Sprintf("%s (%vms) by %s() at ", freqStr, c.TotalDuration.InMillisecondsStr(), c.FullName()),
+			Link:  htmlLink(page, fmt.Sprintf("line %d", callerAt), callerFile, callerAt),
+			After: fmt.Sprintf(", avg %.3fms/call", c.TotalDuration.AverageInMilliseconds(c.Frequency)),
+		})
 	}
+	return notes
 }
 
-func (r *HtmlReporter) showCallsMade(hw *HtmlWriter, lp *json.LineProfile, indent string) {
+/* The notes on the calls made on a line of page, nil without calls */
+func (r *HtmlReporter) showCallsMade(lp *json.LineProfile, indent, page string) *NoteList {
 	/* Time spent calling functions */
 	/* FIXME populate function call metric from lp.Function.Callers */
 	var callTxt string
 	var avgTxt string
 	hideThreshold := 10
 	startHideAt := 0
-	if lp != nil && len(lp.FunctionCalls) > 0 {
-		sort.Stable(lp.FunctionCalls)
+	if lp == nil || len(lp.FunctionCalls) == 0 {
+		return nil
+	}
+	notes := &NoteList{Indent: indent}
+	sort.Stable(lp.FunctionCalls)
 
-		if len(lp.FunctionCalls) > hideThreshold {
-			startHideAt = 5
+	if len(lp.FunctionCalls) > hideThreshold {
+		startHideAt = 5
+	}
+	for i, c := range lp.FunctionCalls {
+		callTxt = "in" // i18n unfriendly
+		avgTxt = ""
+		if c.CallsMade > 1 {
+			callTxt = fmt.Sprintf("making %d calls to", c.CallsMade)
+			avgTxt = fmt.Sprintf(", avg %.3fms/call", c.TimeInFunctions.AverageInMilliseconds(c.CallsMade))
 		}
-		for i, c := range lp.FunctionCalls {
-			if startHideAt > 0 {
-				if i == startHideAt {
-					// TODO check for off by one
-					hw.HiderLink(indent, len(lp.FunctionCalls)-startHideAt)
-				}
-			}
-			callTxt = "in" // i18n unfriendly
-			avgTxt = ""
-			if c.CallsMade > 1 {
-				callTxt = fmt.Sprintf("making %d calls to", c.CallsMade)
-				avgTxt = fmt.Sprintf(", avg %.3fms/call", c.TimeInFunctions.AverageInMilliseconds(c.CallsMade))
-			}
 
-			calleeFQN := c.To.FullName()
-			calleeFile, calleeAt := c.To.Filename, c.To.StartLine-1
+		calleeFQN := c.To.FullName()
+		calleeFile, calleeAt := c.To.Filename, c.To.StartLine-1
 
-			link := calleeFQN
-			if path.IsAbs(calleeFile) {
-				calleeFile = r.htmlLineFilename(calleeFile)
-				link = htmlLink(r.htmlLineFilename(hw.SourceFile), calleeFQN, calleeFile, calleeAt)
-			}
-			hw.commentln(indent, "Spent %vms %s %s()%s",
-				c.TimeInFunctions.InMillisecondsStr(),
-				callTxt,
-				link,
-				avgTxt)
-		}
-		if startHideAt > 0 {
-			hw.Html(`</div>`)
+		link := &Link{Text: calleeFQN}
+		if path.IsAbs(calleeFile) {
+			link = htmlLink(page, calleeFQN, r.htmlLineFilename(calleeFile), calleeAt)
 		}
+		notes.add(startHideAt > 0 && i >= startHideAt, Note{
+			Text:  fmt.Sprintf("Spent %vms %s ", c.TimeInFunctions.InMillisecondsStr(), callTxt),
+			Link:  link,
+			After: "()" + avgTxt,
+		})
 	}
+	return notes
 }
 
-type CodeTableHeader struct {
-	line            string
-	hits            string
-	timeOnLine      string
-	callsMade       string
-	timeInFunctions string
+var cth = report.CodeTableHeaders
+
+/* Headers of the line metrics of source tables, after the line number */
+func (r *HtmlReporter) lineHeaders() []string {
+	return append([]string{cth.Line, cth.Hits, cth.TimeOnLine, cth.CallsMade, cth.TimeInFunctions}, r.metricHeaders()...)
 }
 
-var cth = CodeTableHeader{
-	line:            "Line",
-	hits:            "Hits",
-	timeOnLine:      "Time on line (ms)",
-	callsMade:       "Calls Made",
-	timeInFunctions: "Time in functions",
+func (r *HtmlReporter) lineCells(lp *json.LineProfile, ownTimeStats, otherTimeStats *stats.Stats, metricStats []*stats.Stats) []Cell {
+	if lp == nil {
+		cells := []Cell{{Title: cth.Hits}, {Title: cth.TimeOnLine}, {Title: cth.CallsMade}, {Title: cth.TimeInFunctions}}
+		return append(cells, r.metricCells(nil, metricStats)...)
+	}
+	ownTime := lp.OwnTime()
+	cells := []Cell{
+		titledCell(cth.Hits, lp.Hits.EmptyIfZero()),
+		severityCell(cth.TimeOnLine, report.GetSeverityClass(ownTime.InMilliseconds(), ownTimeStats), ownTime.NonZeroMsOrNone()),
+		titledCell(cth.CallsMade, lp.CallsMade.EmptyIfZero()),
+		severityCell(cth.TimeInFunctions, report.GetSeverityClass(lp.TimeInFunctions.InMilliseconds(), otherTimeStats), lp.TimeInFunctions.NonZeroMsOrNone()),
+	}
+	return append(cells, r.metricCells(lp.Metrics, metricStats)...)
 }
 
-func (r *HtmlReporter) writeOneSourceCodeLine(hw *HtmlWriter, lineNo int, lp *json.LineProfile, sourceLine *string, ownTimeStats, otherTimeStats *stats.Stats) {
+func (r *HtmlReporter) sourceCodeLine(page string, lineNo int, lp *json.LineProfile, sourceLine *string, ownTimeStats, otherTimeStats *stats.Stats, metricStats []*stats.Stats) SourceLine {
 	indent := ""
-	hw.TrOpen()
-	hw.TdOpen(`title="Line number"`)
-	hw.Html(fmt.Sprintf(`<a id="%d">%d</a>`, lineNo, lineNo))
-	hw.TdCloseNoIndent()
-
 	if sourceLine != nil {
-		indent = getFirstWhiteSpaces(*sourceLine)
+		indent = osutil.LeadingWhiteSpace(*sourceLine)
 	}
 
-	if lp == nil {
-		hw.TdTitled(cth.hits, "")
-		hw.TdTitled(cth.timeOnLine, "")
-		hw.TdTitled(cth.callsMade, "")
-		hw.TdTitled(cth.timeInFunctions, "")
-		hw.TdOpen(`class="s"`)
-	} else {
-		ownTime := lp.TotalDuration
-		ownTime.Subtract(lp.TimeInFunctions)
-		if lp.Hits > 0 {
-			hw.TdTitled(cth.hits, lp.Hits)
-		} else {
-			hw.TdTitled(cth.hits, "")
-		}
-
-		hw.TdTitledWithClassOrEmpty(
-			cth.timeOnLine,
-			getSeverityClass(ownTime.InMilliseconds(), ownTimeStats),
-			ownTime.NonZeroMsOrNone(),
-		)
-
-		hw.TdTitled(cth.callsMade, lp.CallsMade.EmptyIfZero())
-		hw.TdTitledWithClassOrEmpty(cth.timeInFunctions, getSeverityClass(lp.TimeInFunctions.InMilliseconds(), otherTimeStats), lp.TimeInFunctions.NonZeroMsOrNone())
-
-		hw.TdOpen(`class="s"`)
-		if lp.Functions != nil {
-			functions := *lp.Functions
-			for _, f := range functions {
-				r.showCallers(hw, f, indent)
-			}
+	line := SourceLine{No: lineNo, Cells: r.lineCells(lp, ownTimeStats, otherTimeStats, metricStats)}
+	if lp != nil && lp.Functions != nil {
+		functions := *lp.Functions
+		for _, f := range functions {
+			line.Callers = append(line.Callers, r.showCallers(f, indent, page))
 		}
 	}
 
 	if sourceLine != nil {
-		hw.Html(html.EscapeString(*sourceLine))
-		r.showCallsMade(hw, lp, indent)
+		line.Code = *sourceLine
+		line.CallsMade = r.showCallsMade(lp, indent, page)
 	}
-	hw.TdCloseNoIndent()
-	hw.TrClose()
+	return line
 }
 
 func makeEmptyLineProfiles(file string) []*json.LineProfile {
 	return make([]*json.LineProfile, osutil.CountLine(file))
 }
 
-func fileExists(file string) bool {
-	if _, err := os.Stat(file); os.IsNotExist(err) {
-		return false
+func (r *HtmlReporter) writeOneSourceCodeHtmlFile(file string, fileProfiles json.FileProfile, rootJsFiles []string, done chan bool) {
+	page := r.htmlLineFilename(file)
+	if r.single == nil {
+		osutil.CreateDir(path.Dir(r.PathTo(page)))
 	}
-	return true
-}
+	defer func() {
+		if done != nil {
+			done <- true
+		}
+	}()
 
-func (r *HtmlReporter) writeOneSourceCodeHtmlFile(file string, fileProfiles json.FileProfile, rootJsFiles []string, done chan bool) {
-	htmlfile := r.ReportDir + "/" + r.htmlLineFilename(file)
-	osutil.CreateDir(path.Dir(htmlfile))
-	hw := NewHtmlWriter(file, htmlfile)
-	defer hw.writeToDiskAsync(done)
-
-	rootPath := pathToRoot(file)
-	jsFiles := []string{}
-	for _, file := range rootJsFiles {
-		jsFiles = append(jsFiles, rootPath+"../"+file)
-	}
-	hw.HtmlWithCssBodyOpen(rootPath+"../css/style.css", jsFiles)
-	hw.DivOpen(`class="left"`)
-	hw.Html(file)
-	hw.DivClose()
-	writeSeverityLegend(hw)
-	hw.TableOpen(`id="function_table"`, `border="1"`, `cellpadding="0"`, `class="sortable clear"`)
-	hw.TheadOpen()
-	hw.Th(cth.line, cth.hits, cth.timeOnLine, cth.callsMade, cth.timeInFunctions)
-	hw.ThOpen(`style="text-align:left"`)
-	hw.Html("Code")
-	hw.ThClose()
-	hw.TheadClose()
-
-	if !fileExists(file) {
+	if !osutil.FileExists(file) {
 		log.Printf("FIXME We should not reach here, file %s should exist\n", file)
 		return
 	}
@@ -500,34 +293,25 @@ func (r *HtmlReporter) writeOneSourceCodeHtmlFile(file string, fileProfiles json
 		log.Printf("Error reading %v:%v\n", file, err)
 		return
 	}
+	defer sourceFile.Close()
 	scanner := bufio.NewScanner(sourceFile)
 	lineProfiles := fileProfiles[file]
 	if lineProfiles == nil {
 		lineProfiles = makeEmptyLineProfiles(file)
 	}
 
-	timesOnLine := make([]float64, 0, len(lineProfiles))
-	timesInFunction := make([]float64, 0, len(lineProfiles))
-	for _, lp := range lineProfiles {
-		if lp == nil {
-			continue
-		}
-		ownTime := lp.TotalDuration
-		ownTime.Subtract(lp.TimeInFunctions)
-		d := ownTime.InMilliseconds()
-		if d > 0 {
-			timesOnLine = append(timesOnLine, d)
-		}
-		d = lp.TimeInFunctions.InMilliseconds()
-		if d > 0 {
-			timesInFunction = append(timesInFunction, d)
-		}
-	}
-	ownTimeStats := stats.MadMedian(timesOnLine)
-	otherTimeStats := stats.MadMedian(timesInFunction)
-
-	hw.TbodyOpen()
+	ownTimeStats, otherTimeStats := report.GetLineMADStats(lineProfiles)
+	metricStats := report.GetLineMetricStats(lineProfiles, r.metrics)
 
+	data := SourcePage{
+		Page:    newPage(file, pathToRoot(file)+"../", rootJsFiles, nil),
+		File:    file,
+		Headers: r.lineHeaders(),
+	}
+	var hl *highlighter
+	if len(lineProfiles) <= HighlightMaxLines {
+		hl = &highlighter{}
+	}
 	var sourceLine *string
 	for i, lp := range lineProfiles {
 		lineNo := i + 1
@@ -536,18 +320,22 @@ func (r *HtmlReporter) writeOneSourceCodeHtmlFile(file string, fileProfiles json
 			line := scanner.Text()
 			sourceLine = &line
 		}
-		r.writeOneSourceCodeLine(hw, lineNo, lp, sourceLine, ownTimeStats, otherTimeStats)
+		line := r.sourceCodeLine(page, lineNo, lp, sourceLine, ownTimeStats, otherTimeStats, metricStats)
+		if hl != nil && sourceLine != nil {
+			line.Spans = hl.line(*sourceLine)
+		}
+		data.Lines = append(data.Lines, line)
+		data.Minimap = append(data.Minimap, minimapMarks(lineNo, len(lineProfiles), lineSeverity(lp, ownTimeStats, otherTimeStats), len(line.Callers) > 0)...)
 	}
-	hw.TbodyClose()
-	hw.TableClose()
-	for i := 0; i < 50; i++ {
-		hw.writeln("<br>")
+	if r.single != nil {
+		r.single.addSource(r.prefix+page, &data)
+		return
 	}
-	hw.BodyClose()
-	hw.HtmlClose()
+	r.writePage("source.html", page, &data)
 }
 
-func (r *HtmlReporter) GenerateJsFiles() {
+/* The scripts of the report by their path in it */
+func jsSources() map[string]string {
 	tableSorterJs := `$.tablesorter.defaults.sortInitialOrder = "desc";`
 	fprofJs := `function srcElement(e) {
 	e = e || window.event;
@@ -568,34 +356,64 @@ function toggleHide(e) {
 		el.innerHTML = el.origHTML;
 	}
 }
+$(document).ready(function(){
+	$(document).on('click', 'div.toggleHide a', function(e) {
+		toggleHide(e);
+		return false;
+	});
+	$(document).on('change', 'div.threads select', function() {
+		fprofGo(this.value);
+	});
+});
+/* Follows a link, the single file report navigates within itself */
+var fprofGo = function(href) {
+	location.href = href;
+};
+/* The fragment of the page URL */
+var fprofAnchor = function() {
+	return decodeURIComponent(location.hash.substring(1));
+};
 `
 	functionsJs := `$(document).ready(function(){
 	$("#functions_table").tablesorter({
 		sortList: [[3,1]]
 	});
+	$("#threads_table").tablesorter();
 });`
 	functionJs := `$(document).ready(function(){
 	$("#function_table").tablesorter();
 });`
 
-	d := r.PathTo("js")
-	jsFiles := map[string]string{
-		path.Join(d, "jquery-min.js"):             JQuery,
-		path.Join(d, "jquery-tablesorter-min.js"): JQueryTableSorter,
-		path.Join(d, "fprof.js"):                  fprofJs,
-		path.Join(d, "tablesorter.js"):            tableSorterJs,
-		path.Join(d, "functions.js"):              functionsJs,
-		path.Join(d, "function.js"):               functionJs,
+	return map[string]string{
+		"js/jquery-min.js":             JQuery,
+		"js/jquery-tablesorter-min.js": JQueryTableSorter,
+		"js/fprof.js":                  fprofJs,
+		"js/tablesorter.js":            tableSorterJs,
+		"js/functions.js":              functionsJs,
+		"js/function.js":               functionJs,
+		"js/timeline.js":               timelineJs,
+		"js/search.js":                 searchJs,
+		"js/callgraph.js":              callGraphJs,
+		"js/minimap.js":                minimapJs,
 	}
+}
 
+func (r *HtmlReporter) GenerateJsFiles() {
+	jsFiles := map[string]string{}
+	for file, js := range jsSources() {
+		jsFiles[r.PathTo(file)] = js
+	}
 	osutil.CreateFiles(jsFiles)
 }
 
 func (r *HtmlReporter) GenerateCssFile() {
 	cssFile := r.ReportDir + "/css/style.css"
 	osutil.CreateDir(path.Dir(cssFile))
-	css := osutil.CreateFile(cssFile)
-	fmt.Fprint(css, `body {
+	fmt.Fprint(osutil.CreateFile(cssFile), cssSource())
+}
+
+func cssSource() string {
+	return `body {
 	font-family: sans-serif;
 }
 .clear {
@@ -634,6 +452,23 @@ td.s {
 .profile_note {
 	color: gray;
 }
+td.s span.kw {
+	color: #00007f;
+	font-weight: bold;
+}
+td.s span.str {
+	color: #a31515;
+}
+td.s span.com {
+	color: #007f00;
+	font-style: italic;
+}
+td.s span.num {
+	color: #7f007f;
+}
+td.s span.typ {
+	color: #00707f;
+}
 .profile_note:hover {
 	color: black;
 	background-color: gray;
@@ -641,6 +476,88 @@ td.s {
 .hide {
 	display: none;
 }
+div.search {
+	position: relative;
+	float: right;
+	clear: right;
+	margin-bottom: 0.5em;
+}
+#fprof_search {
+	width: 25em;
+}
+#fprof_search_results {
+	display: none;
+	position: absolute;
+	right: 0;
+	z-index: 2;
+	width: 50em;
+	max-height: 60vh;
+	overflow: auto;
+	padding: .4em;
+	background: white;
+	border: 1px solid gray;
+	white-space: nowrap;
+}
+#fprof_search_results div.title {
+	font-weight: bold;
+	margin-top: .3em;
+}
+#fprof_search_results span.detail {
+	color: gray;
+	font-family: monospace;
+}
+div.bottom_space {
+	height: 50em;
+}
+#timeline_view {
+	overflow-x: auto;
+}
+#timeline {
+	position: relative;
+	width: 100%;
+}
+div.axis {
+	position: relative;
+	height: 1.5em;
+	border-bottom: 1px solid gray;
+}
+span.tick {
+	position: absolute;
+	border-left: 1px solid gray;
+	padding-left: 2px;
+	font-size: small;
+	white-space: nowrap;
+}
+div.lane {
+	position: relative;
+	margin-bottom: 0.5em;
+	border-bottom: 1px dotted gray;
+}
+.slice {
+	position: absolute;
+	box-sizing: border-box;
+	height: 17px;
+	min-width: 1px;
+	overflow: hidden;
+	white-space: nowrap;
+	font-size: 12px;
+	border-right: 1px solid white;
+	color: black;
+	text-decoration: none;
+}
+.slice:hover {
+	outline: 1px solid black;
+	z-index: 1;
+}
+div.threads {
+	margin-bottom: 0.5em;
+}
+div.warning {
+	border: 1px solid salmon;
+	background: mistyrose;
+	padding: .4em;
+	margin-bottom: 0.5em;
+}
 td.s_low {
 	background: limegreen;
 }
@@ -653,20 +570,95 @@ td.s_high {
 td.s_bad {
 	background: salmon;
 }
+#minimap {
+	position: fixed;
+	top: 0;
+	right: 0;
+	bottom: 0;
+	width: 12px;
+	background: #eee;
+	border-left: 1px solid #ccc;
+}
+#minimap a {
+	position: absolute;
+	left: 0;
+	right: 0;
+	height: 3px;
+}
+#minimap a.s_low {
+	background: limegreen;
+}
+#minimap a.s_medium {
+	background: darkorange;
+}
+#minimap a.s_high {
+	background: lightsalmon;
+}
+#minimap a.s_bad {
+	background: salmon;
+}
+#minimap a.fn {
+	right: auto;
+	width: 4px;
+	height: 2px;
+	background: steelblue;
+}
+#function_table {
+	margin-right: 14px;
+}
+#function_table tr.current td:first-child {
+	background: yellow;
+}
+div.keys {
+	color: gray;
+}
+#callgraph svg {
+	font-family: monospace;
+	font-size: 12px;
+}
+#callgraph g.node {
+	cursor: pointer;
+}
+#callgraph rect {
+	fill: white;
+	stroke: gray;
+}
+#callgraph rect.s_low {
+	fill: limegreen;
+}
+#callgraph rect.s_medium {
+	fill: darkorange;
+}
+#callgraph rect.s_high {
+	fill: lightsalmon;
+}
+#callgraph rect.s_bad {
+	fill: salmon;
+}
+#callgraph g.centre rect {
+	stroke: black;
+	stroke-width: 3;
+}
+#callgraph g.node:hover rect {
+	stroke: black;
+}
+#callgraph path {
+	fill: none;
+	stroke: steelblue;
+	stroke-opacity: 0.6;
+}
+#callgraph text.more {
+	fill: gray;
+}
 
 table.sortable thead tr .header {
 	background-repeat: no-repeat;
 	background-position: 0% 80%;
 	cursor: pointer;
 }
-table.sortable thead tr .headerSortUp   { background-image: url(data:image/png;base64,`)
-	fmt.Fprint(css, ImgAscending)
-
-	fmt.Fprint(css, `); }
-table.sortable thead tr .headerSortDown { background-image: url(data:image/png;base64,`)
-	fmt.Fprint(css, ImgDescending)
-	fmt.Fprint(css, `); }
-`)
+table.sortable thead tr .headerSortUp   { background-image: url(data:image/png;base64,` + ImgAscending + `); }
+table.sortable thead tr .headerSortDown { background-image: url(data:image/png;base64,` + ImgDescending + `); }
+`
 }
 
 func (r *HtmlReporter) generateHtmlFilesParallerWorkers(exists map[string]bool, fileProfiles json.FileProfile, jsFiles []string, nWorkers int) {
@@ -720,7 +712,7 @@ func (r *HtmlReporter) generateHtmlFilesParallerWorkers(exists map[string]bool,
 func (r *HtmlReporter) GenerateSourceCodeHtmlFiles(fileProfiles json.FileProfile, jsFiles []string) map[string]bool {
 	exists := make(map[string]bool)
 	for file, lineProfiles := range fileProfiles {
-		exists[file] = fileExists(file)
+		exists[file] = osutil.FileExists(file)
 		for _, v := range lineProfiles {
 			if v == nil {
 				continue
@@ -732,7 +724,7 @@ func (r *HtmlReporter) GenerateSourceCodeHtmlFiles(fileProfiles json.FileProfile
 				if len(fp.Filename) == 0 {
 					log.Println("Got empty filename from func profile")
 				} else {
-					exists[fp.Filename] = fileExists(fp.Filename)
+					exists[fp.Filename] = osutil.FileExists(fp.Filename)
 				}
 				for _, caller := range fp.Callers {
 					if caller == nil {
@@ -741,7 +733,7 @@ func (r *HtmlReporter) GenerateSourceCodeHtmlFiles(fileProfiles json.FileProfile
 					if len(caller.Filename) == 0 {
 						log.Println("Got empty filename from caller profile")
 					} else {
-						exists[caller.Filename] = fileExists(caller.Filename)
+						exists[caller.Filename] = osutil.FileExists(caller.Filename)
 					}
 				}
 			}
@@ -752,187 +744,140 @@ func (r *HtmlReporter) GenerateSourceCodeHtmlFiles(fileProfiles json.FileProfile
 	return exists
 }
 
-func (hw *HtmlWriter) HtmlWithCssBodyOpen(cssFile string, jsFiles []string) {
-	hw.HtmlOpen()
-	hw.HeadOpen()
-	hw.LinkCss(cssFile)
-	for _, jsFile := range jsFiles {
-		hw.LinkJs(jsFile)
-	}
-	hw.HeadClose()
-	hw.BodyOpen()
-}
-
-func getSeverityClass(v float64, stat *stats.Stats) string {
-	if stat.MAD == 0 {
-		return "s_low"
-	}
-	d := v - stat.Median
-	severity := d / stat.MAD
-	if severity < SEVERITY_LOW {
-		return "s_low"
-	}
-	if severity < SEVERITY_MEDIUM {
-		return "s_medium"
-	}
-	if severity < SEVERITY_HIGH {
-		return "s_high"
-	}
-	return "s_bad"
-}
-
-func getMADStats(functionCalls json.FunctionProfileSlice) (*stats.Stats, *stats.Stats) {
-	ownTimes := make([]float64, 0, len(functionCalls))
-	incTimes := make([]float64, 0, len(functionCalls))
-	for _, fc := range functionCalls {
-		if fc == nil {
-			continue
-		}
-		d := fc.OwnTime.InMilliseconds()
-		if d > 0 {
-			ownTimes = append(ownTimes, d)
-		}
-		d = fc.InclusiveDuration.InMilliseconds()
-		if d > 0 {
-			incTimes = append(incTimes, d)
-		}
-	}
-	ownTimeStat := stats.MadMedian(ownTimes)
-	incTimeStat := stats.MadMedian(incTimes)
-
-	return ownTimeStat, incTimeStat
-}
-
-func (r *HtmlReporter) writeOneFunctionMetric(hw *HtmlWriter, fc *json.FunctionProfile, exists map[string]bool, ownTimeStat *stats.Stats, incTimeStat *stats.Stats) {
+func (r *HtmlReporter) functionRow(fc *json.FunctionProfile, exists map[string]bool, ownTimeStat *stats.Stats, incTimeStat *stats.Stats, metricStats []*stats.Stats) FunctionRow {
 	ieRatio := ""
 	inclMS := fc.InclusiveDuration.InMilliseconds()
 	exclMS := fc.OwnTime.InMilliseconds()
 	if inclMS > 0 {
-		ieRatio = fmt.Sprintf("%3.1f", exclMS*100/inclMS)
-	}
-	hw.TrOpen()
-	hw.TdTitled(fth.calls, fc.Hits)
-	hw.TdTitled(fth.places, fc.CountCallingPlaces())
-	hw.TdTitled(fth.files, fc.CountCallingFiles())
-
-	hw.TdTitledWithClassOrEmpty(
-		fth.selfMs,
-		getSeverityClass(exclMS, ownTimeStat),
-		fc.OwnTime.NonZeroMsOrNone(),
-	)
-
-	hw.TdTitledWithClassOrEmpty(
-		fth.inclusiveMs,
-		getSeverityClass(inclMS, incTimeStat),
-		fc.InclusiveDuration.NonZeroMsOrNone(),
-	)
-
-	hw.TdTitled(fth.ratio, ieRatio)
-
-	hw.TdOpen(`class="s"`)
+		ieRatio = fmt.Sprintf("%3.1f", fc.OwnTimeRatio())
+	}
+	row := FunctionRow{Cells: []Cell{
+		titledCell(fth.Calls, fc.Hits),
+		titledCell(fth.Places, fc.CountCallingPlaces()),
+		titledCell(fth.Files, fc.CountCallingFiles()),
+		severityCell(
+			fth.SelfMs,
+			report.GetSeverityClass(exclMS, ownTimeStat),
+			fc.OwnTime.NonZeroMsOrNone(),
+		),
+		severityCell(
+			fth.InclusiveMs,
+			report.GetSeverityClass(inclMS, incTimeStat),
+			fc.InclusiveDuration.NonZeroMsOrNone(),
+		),
+		titledCell(fth.Ratio, ieRatio),
+	}}
+	row.Cells = append(row.Cells, r.metricCells(fc.Metrics, metricStats)...)
+
+	row.Search = fc.FullName() + " " + fc.Filename
+	row.Name = r.functionPages.link(fc, fc.FullName(), "")
+	row.CallGraph = Link{Href: callGraphHref(fc.FullName()), Text: "[graph]"}
 	if exists[fc.Filename] {
-		hw.write(htmlLink(".", fc.FullName(), r.htmlLineFilename(fc.Filename), fc.StartLine))
+		row.Source = htmlLink(".", "[source]", r.htmlLineFilename(fc.Filename), fc.StartLine)
 	} else {
-		r.showCallers(hw, fc, "")
-		hw.write(fc.FullName())
-	}
-	hw.TdCloseNoIndent()
-	hw.TrClose()
-}
-
-var tableAttrs = []string{`border="1"`, `cellpadding="0"`}
-
-func writeSeverityLegend(hw *HtmlWriter) {
-	legend := []map[string]string{
-		{"s_bad": "Bad"},
-		{"s_high": "High"},
-		{"s_medium": "Medium"},
-		{"s_low": "Low"},
-	}
-	hw.DivOpen(`class="legend"`)
-	hw.Html("Severity:")
-	hw.TableOpen(tableAttrs...)
-	hw.TrOpen()
-	for _, u := range legend {
-		for k, v := range u {
-			hw.TdWithClassOrEmpty(k, " ")
-			hw.Td(v)
-		}
+		row.Callers = r.showCallers(fc, "", "functions.html")
 	}
-	hw.TrClose()
-	hw.TableClose()
-	hw.DivClose()
+	return row
 }
 
-type FunctionTableHeader struct {
-	calls       string
-	places      string
-	files       string
-	selfMs      string
-	inclusiveMs string
-	ratio       string
+/* One column for each custom metric declared by the profile */
+func (r *HtmlReporter) metricHeaders() []string {
+	headers := []string{}
+	for i := range r.metrics {
+		headers = append(headers, r.metrics[i].Title())
+	}
+	return headers
 }
 
-var fth = FunctionTableHeader{
-	calls:       "Calls",
-	places:      "Places",
-	files:       "Files",
-	selfMs:      "Self (ms)",
-	inclusiveMs: "Inclusive (ms)",
-	ratio:       "Incl/Excl %",
+func (r *HtmlReporter) metricCells(metrics json.Metrics, metricStats []*stats.Stats) []Cell {
+	cells := []Cell{}
+	for i := range r.metrics {
+		m := &r.metrics[i]
+		cells = append(cells, severityCell(
+			m.Title(),
+			report.GetSeverityClass(metrics[m.Name], metricStats[i]),
+			report.MetricValueOrNone(metrics, m.Name),
+		))
+	}
+	return cells
 }
 
+var fth = report.FunctionTableHeaders
+
 func (r *HtmlReporter) GenerateFunctionsHtmlFile(p *json.Profile, jsFiles []string, exists map[string]bool, functionCalls json.FunctionProfileSlice) {
-	hw := NewHtmlWriter("", r.ReportDir+"/functions.html")
-	defer hw.writeToDiskAsync(nil)
-
-	ownTimeStat, incTimeStat := getMADStats(functionCalls)
-
-	hw.HtmlWithCssBodyOpen("css/style.css", jsFiles)
-	hw.DivOpen(`class="left"`)
-	hw.Div("Start: " + p.Start.Time())
-	hw.Div("Stop: " + p.Stop.Time())
-	hw.Div("Duration: " + p.Duration.InMillisecondsStr() + "ms")
-	hw.DivClose()
-	writeSeverityLegend(hw)
-	attrs := []string{`id="functions_table"`, `class="sortable clear"`}
-	attrs = append(attrs, tableAttrs...)
-	hw.TableOpen(attrs...)
-	hw.TheadOpen()
-	hw.Th(fth.calls, fth.places, fth.files, fth.selfMs, fth.inclusiveMs, fth.ratio)
-	hw.ThOpen(`style="text-align:left"`)
-	hw.Html("Function")
-	hw.ThClose()
-	hw.TheadClose()
-	hw.TbodyOpen()
+	ownTimeStat, incTimeStat := report.GetMADStats(functionCalls)
+	metricStats := report.GetFunctionMetricStats(functionCalls, r.metrics)
+
+	data := FunctionsPage{
+		Page:     newPage("Functions", "", jsFiles, p.Warnings),
+		Start:    p.Start.Time(),
+		Stop:     p.Stop.Time(),
+		Duration: p.Duration.InMillisecondsStr(),
+		Timeline: len(p.Events) > 0,
+		Headers:  append([]string{fth.Calls, fth.Places, fth.Files, fth.SelfMs, fth.InclusiveMs, fth.Ratio}, r.metricHeaders()...),
+	}
+	if r.threads != nil {
+		data.Threads = r.threads.options()
+		if r.threads.current == "" {
+			data.ThreadHeaders = threadTableHeaders
+			data.ThreadRows = r.threads.rows(p)
+		}
+	}
 	for _, fc := range functionCalls {
 		if fc == nil {
 			continue
 		}
-		r.writeOneFunctionMetric(hw, fc, exists, ownTimeStat, incTimeStat)
+		data.Functions = append(data.Functions, r.functionRow(fc, exists, ownTimeStat, incTimeStat, metricStats))
 	}
-	hw.TbodyClose()
-	hw.TableClose()
-	hw.BodyClose()
-	hw.HtmlClose()
+	r.writePage("functions.html", "functions.html", &data)
 }
 
 func (r *HtmlReporter) ReportFunctions(p *json.Profile) {
+	if r.templates == nil {
+		templates, err := loadTemplates(r.Templates)
+		if err != nil {
+			log.Fatal("Loading templates: ", err)
+		}
+		r.templates = templates
+	}
 	fileProfiles := p.FileProfileMap
-	r.GenerateCssFile()
-	r.GenerateJsFiles()
+	r.metrics = p.Metrics
+	if r.threads == nil && len(p.Threads) > 0 {
+		r.threads = newThreadSelector(p)
+	}
+	if r.single == nil {
+		r.GenerateCssFile()
+		r.GenerateJsFiles()
+	}
 	log.Println("Cross referencing function call metrics...")
 	functionCalls := fileProfiles.GetFunctionsSortedByExlusiveTime()
+	r.functionPages = newFunctionPages(functionCalls)
 
 	jsFiles := []string{
 		"js/jquery-min.js",
 		"js/jquery-tablesorter-min.js",
 		"js/tablesorter.js",
 		"js/fprof.js",
-		"js/function.js",
+		"js/search-index.js",
+		"js/search.js",
+	}
+	withJs := func(js string) []string {
+		return append(append([]string{}, jsFiles...), js)
+	}
+
+	exists := r.GenerateSourceCodeHtmlFiles(fileProfiles, append(withJs("js/function.js"), "js/minimap.js"))
+	r.GenerateSearchIndex(functionCalls, exists)
+	r.GenerateFunctionHtmlFiles(p, jsFiles, exists, functionCalls)
+	if len(p.Events) > 0 {
+		r.GenerateTimelineHtmlFile(p, jsFiles, exists)
 	}
+	r.GenerateCallGraphHtmlFile(p, jsFiles, functionCalls)
+	r.GenerateFunctionsHtmlFile(p, withJs("js/functions.js"), exists, functionCalls)
 
-	exists := r.GenerateSourceCodeHtmlFiles(fileProfiles, jsFiles)
-	jsFiles[4] = "js/functions.js"
-	r.GenerateFunctionsHtmlFile(p, jsFiles, exists, functionCalls)
+	if r.threads != nil && r.threads.current == "" {
+		r.reportThreads(p)
+	}
+	if r.single != nil && r.prefix == "" {
+		r.writeSingleFile(p)
+	}
 }
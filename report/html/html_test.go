@@ -1,9 +1,24 @@
 package html
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	stdjson "encoding/json"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
+import "fprof/json"
+import "fprof/log"
+import "fprof/report"
+import "fprof/stats"
+
 func reportFailure(t *testing.T, got, expected, fmt string, args ...interface{}) {
 	t.Fail()
 	t.Logf(fmt, args...)
@@ -61,3 +76,345 @@ func TestStripCommonPath(t *testing.T) {
 	testStripCommonPath(t, "a/a.txt", "b/b.txt", "a/a.txt", "b/b.txt")
 	testStripCommonPath(t, "a/a.txt", "a/b.txt", "a.txt", "b.txt")
 }
+
+func TestFunctionPageNames(t *testing.T) {
+	functions := json.FunctionProfileSlice{nil}
+	for _, name := range []string{"Obj.f", "obj.f", "Obj.f", "a/b"} {
+		f := &json.FunctionProfile{}
+		f.Name = name
+		functions = append(functions, f)
+	}
+	fp := newFunctionPages(functions)
+	expected := []string{"functions/Obj.f.html", "functions/obj.f-2.html", "functions/Obj.f-3.html", "functions/a_b.html"}
+	for i, want := range expected {
+		if got := fp.pages[functions[i+1]]; got != want {
+			reportFailure(t, got, want, "page of function %d", i)
+		}
+	}
+}
+
+func TestThreadDirs(t *testing.T) {
+	dirs := threadDirs([]string{"1.2", "1_2", "A", "a", "../x"})
+	expected := map[string]string{"1.2": "threads/1_2", "1_2": "threads/1_2-2", "A": "threads/A", "a": "threads/a-2", "../x": "threads/___x"}
+	for id, want := range expected {
+		if got := dirs[id]; got != want {
+			reportFailure(t, got, want, "dir of thread %s", id)
+		}
+	}
+}
+
+func TestTemplatesOverride(t *testing.T) {
+	dir := t.TempDir()
+	header := `<div id="brand">{{.Title}}</div>`
+	if err := os.WriteFile(filepath.Join(dir, "header.tmpl"), []byte(header), 0644); err != nil {
+		t.Fatal(err)
+	}
+	templates, err := loadTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notes := &NoteList{Indent: "  "}
+	for i := 0; i < 3; i++ {
+		notes.add(i > 0, Note{Text: "by ", Link: &Link{Href: "a.html#1", Text: "<f>"}})
+	}
+	data := FunctionsPage{
+		Page:      newPage("Functions", "", nil, nil),
+		Functions: []FunctionRow{{Name: Link{Text: "f"}, Callers: notes}},
+	}
+	var b bytes.Buffer
+	if err := templates.ExecuteTemplate(&b, "functions.html", &data); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<div id="brand">Functions</div>`,
+		`<a href="a.html#1">&lt;f&gt;</a>`,
+		`Show 2 more ...</a></div><div class="hide"><div class="profile_note">  // by `,
+	} {
+		if !strings.Contains(b.String(), want) {
+			reportFailure(t, b.String(), want, "functions.html with a header template")
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "footer.tmpl"), []byte(`{{.Nope`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadTemplates(dir); err == nil {
+		t.Error("expected an error for a broken template")
+	}
+}
+
+var urlPathTests = []struct {
+	path, want string
+}{
+	{"files/a/b.fe.html", "files/a/b.fe.html"},
+	{"../x\"<y/a#b?.fe.html", "../x%22%3Cy/a%23b%3F.fe.html"},
+	{"javascript:alert(1).html", "./javascript:alert%281%29.html"},
+	{"a/b:c.html", "a/b:c.html"},
+}
+
+func TestUrlPath(t *testing.T) {
+	for i, tt := range urlPathTests {
+		if got := urlPath(tt.path); got != tt.want {
+			t.Errorf("%d. urlPath(%q)\n Got %q\nwant %q", i, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestSourcePageEscaping(t *testing.T) {
+	templates, err := loadTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notes := &NoteList{}
+	notes.add(false, Note{Text: `<b>"f"</b> at `, Link: &Link{Href: "javascript:alert(1)", Text: "<i>"}})
+	data := SourcePage{
+		Page:  newPage("<t>", "../", []string{"js/a.js"}, []string{"<w>"}),
+		File:  "<t>",
+		Lines: []SourceLine{{No: 1, Code: `x = "<script>";`, CallsMade: notes}},
+	}
+	var b bytes.Buffer
+	if err := templates.ExecuteTemplate(&b, "source.html", &data); err != nil {
+		t.Fatal(err)
+	}
+	page := b.String()
+	for _, bad := range []string{"<t>", "<w>", "<script>", "<b>", "<i>", "javascript:"} {
+		if strings.Contains(page, bad) {
+			t.Errorf("unescaped %s in %s", bad, page)
+		}
+	}
+	if !strings.Contains(page, `http-equiv="Content-Security-Policy"`) {
+		t.Errorf("no Content-Security-Policy in %s", page)
+	}
+}
+
+func TestFunctionSource(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "a.fe")
+	if err := os.WriteFile(source, []byte("function f() {\n}\nfunction g() {\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, g := &json.FunctionProfile{}, &json.FunctionProfile{}
+	f.Name, f.Filename, f.StartLine = "f", source, 1
+	g.Name, g.Filename, g.StartLine = "g", source, 3
+	fileProfiles := json.FileProfile{source: {
+		{Functions: &json.FunctionProfileSlice{f}}, &json.LineProfile{},
+		{Functions: &json.FunctionProfileSlice{g}}, &json.LineProfile{},
+	}}
+	exists := map[string]bool{source: true}
+	r := &HtmlReporter{}
+	sf := r.newFunctionSourceFile(fileProfiles, source, exists)
+	for _, tt := range []struct {
+		f    *json.FunctionProfile
+		code []string
+	}{{f, []string{"function f() {", "}"}}, {g, []string{"function g() {", "}"}}} {
+		data := FunctionPage{}
+		r.functionSource(&data, fileProfiles, tt.f, sf, exists)
+		code := []string{}
+		for _, l := range data.Source {
+			code = append(code, l.Code)
+		}
+		if !reflect.DeepEqual(code, tt.code) {
+			reportFailure(t, strings.Join(code, "|"), strings.Join(tt.code, "|"), "source of %s", tt.f.Name)
+		}
+	}
+	if r.newFunctionSourceFile(fileProfiles, source, map[string]bool{}) != nil {
+		t.Errorf("A file that does not exist must not be read")
+	}
+}
+
+var highlightTests = []struct {
+	lines []string
+	want  [][]Span
+}{
+	{[]string{`uses "console";`}, [][]Span{{{"kw", "uses"}, {"", " "}, {"str", `"console"`}, {"", ";"}}}},
+	{[]string{`class Foo extends bar {`}, [][]Span{{{"kw", "class"}, {"", " "}, {"typ", "Foo"}, {"", " "}, {"kw", "extends"}, {"", " "}, {"typ", "bar"}, {"", " {"}}}},
+	{[]string{`x = 'a\'b' + 0x1F + 2.5e-3; // done`}, [][]Span{{{"", "x = "}, {"str", `'a\'b'`}, {"", " + "}, {"num", "0x1F"}, {"", " + "}, {"num", "2.5e-3"}, {"", "; "}, {"com", "// done"}}}},
+	{[]string{`a(); /* one`, `two */ Console.println("x");`}, [][]Span{
+		{{"", "a(); "}, {"com", "/* one"}},
+		{{"com", "two */"}, {"", " "}, {"typ", "Console"}, {"", ".println("}, {"str", `"x"`}, {"", ");"}},
+	}},
+	{[]string{""}, [][]Span{{}}},
+}
+
+func TestHighlight(t *testing.T) {
+	for i, tt := range highlightTests {
+		h := &highlighter{}
+		for j, line := range tt.lines {
+			if got := h.line(line); !reflect.DeepEqual(got, tt.want[j]) {
+				t.Errorf("%d. line %d %q\n Got %q\nwant %q", i, j+1, line, got, tt.want[j])
+			}
+		}
+	}
+}
+
+func TestCallGraphData(t *testing.T) {
+	log.Init(io.Discard, "")
+	p := json.DecodeFromBytes([]byte(`{"files":{"a.fe":[
+		{"functions":[{"name":"main","start_line":1,"hits":1,"exclusive_duration":{"sec":0,"nsec":19000},"inclusive_duration":{"sec":0,"nsec":20000}}]},
+		{"functions":[{"name":"f","start_line":2,"hits":3,"inclusive_duration":{"sec":0,"nsec":8000},"callers":[
+			{"at":1,"file":"a.fe","frequency":3,"name":"main","total_duration":{"sec":0,"nsec":8000}},
+			{"at":1,"file":"b.fe","frequency":1,"name":"g#h","total_duration":{"sec":0,"nsec":1000}}]}]},
+		{"functions":[{"name":"k1","start_line":3,"hits":1,"inclusive_duration":{"sec":0,"nsec":2000}}]},
+		{"functions":[{"name":"k2","start_line":4,"hits":1,"inclusive_duration":{"sec":0,"nsec":3000}}]},
+		{"functions":[{"name":"k3","start_line":5,"hits":1,"inclusive_duration":{"sec":0,"nsec":4000}}]}]}}`))
+	functionCalls := p.FileProfileMap.GetFunctionsSortedByExlusiveTime()
+	r := New(t.TempDir())
+	r.functionPages = newFunctionPages(functionCalls)
+	data := r.newCallGraphData(functionCalls)
+
+	if data.Centre != "main" || len(data.Nodes) != 6 || len(data.Edges) != 2 {
+		t.Fatalf("unexpected call graph %v", data)
+	}
+	names := map[string]int{}
+	for i, n := range data.Nodes {
+		names[n[0].(string)] = i
+	}
+	if page := data.Nodes[names["f"]][1]; page != "functions/f.html" {
+		t.Errorf("page of f is %v", page)
+	}
+	if page := data.Nodes[names["g#h"]][1]; page != "" {
+		t.Errorf("g#h did not get profiled but has page %v", page)
+	}
+	/* main has by far the most inclusive time but little self time */
+	ownTimeStat, _ := report.GetMADStats(functionCalls)
+	for _, f := range functionCalls {
+		if f == nil {
+			continue
+		}
+		if class, want := data.Nodes[names[f.Name]][2], report.GetSeverityClass(f.OwnTime.InMilliseconds(), ownTimeStat); class != want {
+			reportFailure(t, class.(string), want, "class of %s by its self time", f.Name)
+		}
+	}
+	if class := data.Nodes[names["main"]][2]; class != "s_low" {
+		t.Errorf("main is %v by its self time", class)
+	}
+	if e := data.Edges[0]; e[0] != names["main"] || e[1] != names["f"] || e[2] != json.Counter(3) {
+		t.Errorf("heaviest edge is %v", e)
+	}
+	if href := callGraphHref("g#h"); href != "callgraph.html#g%23h" {
+		t.Errorf("callGraphHref(g#h) = %s", href)
+	}
+}
+
+func TestMinimap(t *testing.T) {
+	stat := &stats.Stats{MAD: 1, Median: 1}
+	lp := &json.LineProfile{TotalDuration: json.TimeSpec{Nsec: 3500000}, TimeInFunctions: json.TimeSpec{Nsec: 1000000}}
+	/* 2.5ms on the line is high, 1ms in functions low */
+	if class := lineSeverity(lp, stat, stat); class != "s_high" {
+		t.Errorf("lineSeverity of %v is %s", lp, class)
+	}
+	if class := lineSeverity(&json.LineProfile{}, stat, stat); class != "" {
+		t.Errorf("a line without time has severity %s", class)
+	}
+	marks := minimapMarks(3, 8, "s_bad", true)
+	want := []MinimapMark{{3, "s_bad", "25.000"}, {3, "fn", "25.000"}}
+	if !reflect.DeepEqual(marks, want) {
+		t.Errorf("minimapMarks\n Got %v\nwant %v", marks, want)
+	}
+	if marks := minimapMarks(1, 8, "", false); len(marks) != 0 {
+		t.Errorf("marks for a line without time: %v", marks)
+	}
+}
+
+func TestSearchIndex(t *testing.T) {
+	log.Init(io.Discard, "")
+	dir := t.TempDir()
+	source := filepath.Join(dir, "a.fe")
+	if err := os.WriteFile(source, []byte("function f() {\n\n    return 1;\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	eval := filepath.Join(dir, "eval()#1")
+	p := json.DecodeFromBytes([]byte(`{"files":{"` + source + `":[
+		{"functions":[{"name":"f","start_line":1,"hits":1,"inclusive_duration":{"sec":0,"nsec":3000}}],"hits":1}]}}`))
+	functionCalls := p.FileProfileMap.GetFunctionsSortedByExlusiveTime()
+	exists := map[string]bool{source: true, eval: true}
+
+	r := New(filepath.Join(dir, "report"))
+	r.functionPages = newFunctionPages(functionCalls)
+	index := r.newSearchIndex(functionCalls, exists)
+	page := urlPath(path.Clean("files/" + source + ".html"))
+	if len(index.Functions) != 1 || !reflect.DeepEqual(index.Functions[0], []interface{}{"f", source, json.Counter(1), "functions/f.html"}) {
+		t.Errorf("functions of the index: %v", index.Functions)
+	}
+	if !reflect.DeepEqual(index.Files, [][]string{{source, page}}) {
+		t.Errorf("files of the index, eval()'d code included?: %v", index.Files)
+	}
+	/* Blank lines are left out */
+	if len(index.Lines) != 3 || !reflect.DeepEqual(index.Lines[1], []interface{}{0, 3, "return 1;"}) {
+		t.Errorf("lines of the index: %v", index.Lines)
+	}
+
+	defer func(max int) { SearchIndexMaxLines = max }(SearchIndexMaxLines)
+	SearchIndexMaxLines = 2
+	if index := r.newSearchIndex(functionCalls, exists); len(index.Lines) != 2 {
+		t.Errorf("%d lines in the index, want %d", len(index.Lines), SearchIndexMaxLines)
+	}
+
+	r.GenerateSearchIndex(functionCalls, exists)
+	if js, err := os.ReadFile(r.PathTo("js/search-index.js")); err != nil || !strings.HasPrefix(string(js), "var fprofSearchIndex = {") {
+		t.Errorf("js/search-index.js not written: %v %.40s", err, js)
+	}
+
+	single := NewSingleFile(filepath.Join(dir, "single", "report.html"))
+	thread := &HtmlReporter{single: single.single, prefix: "threads/1/"}
+	thread.functionPages = r.functionPages
+	thread.GenerateSearchIndex(functionCalls, exists)
+	if single.single.search != nil {
+		t.Error("a thread sub-report of a single file report set the search index")
+	}
+	single.functionPages = r.functionPages
+	single.GenerateSearchIndex(functionCalls, exists)
+	if single.single.search == nil {
+		t.Error("no search index for the single file report")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "single", "js")); !os.IsNotExist(err) {
+		t.Errorf("the single file report wrote into its dir: %v", err)
+	}
+}
+
+func TestSingleFile(t *testing.T) {
+	log.Init(io.Discard, "")
+	dir := t.TempDir()
+	source := filepath.Join(dir, "a.fe")
+	if err := os.WriteFile(source, []byte("function f() {\n    return 1;\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := json.DecodeFromBytes([]byte(`{"duration":{"sec":0,"nsec":3000},"files":{"` + source + `":[
+		{"functions":[{"name":"f","start_line":1,"hits":2,"inclusive_duration":{"sec":0,"nsec":3000}}],"hits":2},
+		{"hits":2,"total_duration":{"sec":0,"nsec":3000}}]}}`))
+	file := filepath.Join(dir, "report.html")
+	NewSingleFile(file).ReportFunctions(p)
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("expected a.fe and report.html only, got %v", entries)
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := string(b)
+	start := strings.Index(page, `id="fprof_data">`) + len(`id="fprof_data">`)
+	var data singleData
+	if err := stdjson.Unmarshal([]byte(page[start:start+strings.Index(page[start:], "</script>")]), &data); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"functions.html", "functions/f.html"} {
+		if _, ok := data.Pages[key]; !ok {
+			t.Errorf("no page %s in %v", key, data.Pages)
+		}
+	}
+	s := data.Sources[path.Clean("files/"+source+".html")]
+	if s == nil || len(s.Lines) != 2 {
+		t.Fatalf("source of %s not as expected: %v", source, data.Sources)
+	}
+	if code, _ := stdjson.Marshal(s.Lines[1][0]); string(code) != `["    ",["return","kw"]," ",["1","num"],";"]` {
+		t.Errorf("line 2 of %s is %s", source, code)
+	}
+
+	script := page[strings.Index(page, "<script>")+len("<script>"):]
+	script = script[:strings.Index(script, "</script>")]
+	hash := sha256.Sum256([]byte(script))
+	if !strings.Contains(page, "sha256-"+base64.StdEncoding.EncodeToString(hash[:])) {
+		t.Error("the Content-Security-Policy does not allow the script")
+	}
+}
@@ -585,46 +585,26 @@ const JQueryTableSorter = `/*
                     var sortTime = new Date();
                 }
 
-                var dynamicExp = "var sortWrapper = function(a,b) {",
-                    l = sortList.length;
-
-                // TODO: inline functions.
+                /* Compares with closures rather than eval()'d code, as fprof reports forbid eval */
+                var l = sortList.length,
+                    compares = [];
                 for (var i = 0; i < l; i++) {
-
                     var c = sortList[i][0];
                     var order = sortList[i][1];
-                    // var s = (getCachedSortType(table.config.parsers,c) == "text") ?
-                    // ((order == 0) ? "sortText" : "sortTextDesc") : ((order == 0) ?
-                    // "sortNumeric" : "sortNumericDesc");
-                    // var s = (table.config.parsers[c].type == "text") ? ((order == 0)
-                    // ? makeSortText(c) : makeSortTextDesc(c)) : ((order == 0) ?
-                    // makeSortNumeric(c) : makeSortNumericDesc(c));
-                    var s = (table.config.parsers[c].type == "text") ? ((order == 0) ? makeSortFunction("text", "asc", c) : makeSortFunction("text", "desc", c)) : ((order == 0) ? makeSortFunction("numeric", "asc", c) : makeSortFunction("numeric", "desc", c));
-                    var e = "e" + i;
-
-                    dynamicExp += "var " + e + " = " + s; // + "(a[" + c + "],b[" + c
-                    // + "]); ";
-                    dynamicExp += "if(" + e + ") { return " + e + "; } ";
-                    dynamicExp += "else { ";
-
+                    compares.push(makeSortFunction(table.config.parsers[c].type == "text" ? "text" : "numeric", (order == 0) ? "asc" : "desc", c));
                 }
 
                 // if value is the same keep orignal order
                 var orgOrderCol = cache.normalized[0].length - 1;
-                dynamicExp += "return a[" + orgOrderCol + "]-b[" + orgOrderCol + "];";
-
-                for (var i = 0; i < l; i++) {
-                    dynamicExp += "}; ";
-                }
-
-                dynamicExp += "return 0; ";
-                dynamicExp += "}; ";
-
-                if (table.config.debug) {
-                    benchmark("Evaling expression:" + dynamicExp, new Date());
-                }
-
-                eval(dynamicExp);
+                var sortWrapper = function(a, b) {
+                    for (var i = 0; i < l; i++) {
+                        var e = compares[i](a, b);
+                        if (e) {
+                            return e;
+                        }
+                    }
+                    return a[orgOrderCol] - b[orgOrderCol];
+                };
 
                 cache.normalized.sort(sortWrapper);
 
@@ -636,17 +616,19 @@ const JQueryTableSorter = `/*
             };
 
             function makeSortFunction(type, direction, index) {
-                var a = "a[" + index + "]",
-                    b = "b[" + index + "]";
-                if (type == 'text' && direction == 'asc') {
-                    return "(" + a + " == " + b + " ? 0 : (" + a + " === null ? Number.POSITIVE_INFINITY : (" + b + " === null ? Number.NEGATIVE_INFINITY : (" + a + " < " + b + ") ? -1 : 1 )));";
-                } else if (type == 'text' && direction == 'desc') {
-                    return "(" + a + " == " + b + " ? 0 : (" + a + " === null ? Number.POSITIVE_INFINITY : (" + b + " === null ? Number.NEGATIVE_INFINITY : (" + b + " < " + a + ") ? -1 : 1 )));";
-                } else if (type == 'numeric' && direction == 'asc') {
-                    return "(" + a + " === null && " + b + " === null) ? 0 :(" + a + " === null ? Number.POSITIVE_INFINITY : (" + b + " === null ? Number.NEGATIVE_INFINITY : " + a + " - " + b + "));";
-                } else if (type == 'numeric' && direction == 'desc') {
-                    return "(" + a + " === null && " + b + " === null) ? 0 :(" + a + " === null ? Number.POSITIVE_INFINITY : (" + b + " === null ? Number.NEGATIVE_INFINITY : " + b + " - " + a + "));";
+                var asc = direction == 'asc';
+                if (type == 'text') {
+                    return function(a, b) {
+                        var x = a[index],
+                            y = b[index];
+                        return (x == y ? 0 : (x === null ? Number.POSITIVE_INFINITY : (y === null ? Number.NEGATIVE_INFINITY : (asc ? x < y : y < x) ? -1 : 1)));
+                    };
                 }
+                return function(a, b) {
+                    var x = a[index],
+                        y = b[index];
+                    return (x === null && y === null) ? 0 : (x === null ? Number.POSITIVE_INFINITY : (y === null ? Number.NEGATIVE_INFINITY : (asc ? x - y : y - x)));
+                };
             };
 
             function makeSortText(i) {
@@ -0,0 +1,92 @@
+package html
+
+import (
+	"strconv"
+)
+
+import "fprof/json"
+import "fprof/report"
+import "fprof/stats"
+
+/*
+ * The minimap of a source page is a gutter along the window with a mark for
+ * every line that took time, in the colour of its severity, and one for
+ * every function defined. Keys jump to the next or previous line of at least
+ * medium severity and to the next function.
+ */
+const minimapJs = `var minimapKeys = {n: ['hot', true], p: ['hot', false], f: ['fn', true]};
+function minimapLines(kind) {
+	var selector = kind == 'fn' ? '#minimap a.fn' : '#minimap a.s_medium, #minimap a.s_high, #minimap a.s_bad';
+	return $(selector).map(function() {
+		return parseInt(this.getAttribute('data-line'), 10);
+	}).get().sort(function(a, b) { return a - b; });
+}
+/* Goes to the first of lines below the top of the window, or the last above */
+function minimapJump(lines, forward) {
+	var to = null;
+	for (var i = 0; i < lines.length; i++) {
+		var el = document.getElementById(String(lines[i]));
+		if (!el) continue;
+		var top = el.getBoundingClientRect().top;
+		if (forward && top > 2) {
+			to = lines[i];
+			break;
+		}
+		if (!forward && top < -2) to = lines[i];
+	}
+	if (to === null) return;
+	$('#function_table tr.current').removeClass('current');
+	$(document.getElementById(String(to))).closest('tr').addClass('current');
+	fprofGo('#' + to);
+}
+$(document).on('keydown', function(e) {
+	var key = minimapKeys[e.key];
+	if (!key || !$('#minimap').length || e.ctrlKey || e.altKey || e.metaKey) return;
+	if (/^(INPUT|SELECT|TEXTAREA)$/.test(e.target.tagName)) return;
+	minimapJump(minimapLines(key[0]), key[1]);
+	return false;
+});
+`
+
+func severityIndex(class string) int {
+	for i, c := range report.SeverityClasses {
+		if c == class {
+			return i
+		}
+	}
+	return -1
+}
+
+/* The worse of the severity of the time on a line and in the functions it calls, "" without time */
+func lineSeverity(lp *json.LineProfile, ownTimeStats, otherTimeStats *stats.Stats) string {
+	if lp == nil {
+		return ""
+	}
+	worst := -1
+	ownTime := lp.OwnTime()
+	if ms := ownTime.InMilliseconds(); ms > 0 {
+		worst = severityIndex(report.GetSeverityClass(ms, ownTimeStats))
+	}
+	if ms := lp.TimeInFunctions.InMilliseconds(); ms > 0 {
+		if i := severityIndex(report.GetSeverityClass(ms, otherTimeStats)); i > worst {
+			worst = i
+		}
+	}
+	if worst < 0 {
+		return ""
+	}
+	return report.SeverityClasses[worst]
+}
+
+/* The marks of line no of lines on the minimap, given its severity class */
+func minimapMarks(no, lines int, class string, defines bool) []MinimapMark {
+	marks := []MinimapMark{}
+	top := strconv.FormatFloat(float64(no-1)*100/float64(lines), 'f', 3, 64)
+	if class != "" {
+		marks = append(marks, MinimapMark{no, class, top})
+	}
+	if defines {
+		marks = append(marks, MinimapMark{no, "fn", top})
+	}
+	return marks
+}
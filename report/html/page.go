@@ -0,0 +1,246 @@
+package html
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+import "fprof/log"
+import "fprof/osutil"
+
+/*
+ * The data models the templates of templates.go render. Whatever overriding
+ * templates may use is exported here.
+ */
+
+/* What every page has, the data of the "head" and "foot" templates */
+type Page struct {
+	Title string
+	/* Relative path from the page to the report root, "" or ending in "/" */
+	Root string
+	/* Relative to the report root */
+	JsFiles  []string
+	Warnings []string
+}
+
+/* A table cell, Title is its column header */
+type Cell struct {
+	Title, Class, Value string
+}
+
+/* A link, plain text without Href. Eval marks links into eval()'d code */
+type Link struct {
+	Href, Text string
+	Eval       bool
+}
+
+/* A profile note shown with a source line, a comment of Indent */
+type Note struct {
+	Indent, Text string
+	Link         *Link
+	After        string
+}
+
+/* Notes shown with a line, the Hidden ones behind a "Show more" toggle */
+type NoteList struct {
+	Indent string
+	Notes  []Note
+	Hidden []Note
+}
+
+/* A line of a source page, or of the source excerpt of a function page */
+type SourceLine struct {
+	No int
+	/* The line number, linked to the source page from function pages */
+	Link  Link
+	Cells []Cell
+	/* About the functions starting on the line */
+	Callers []*NoteList
+	Code    string
+	/* Code highlighted, empty when it is not */
+	Spans     []Span
+	CallsMade *NoteList
+}
+
+type ThreadOption struct {
+	Value, Label string
+	Selected     bool
+}
+
+type ThreadRow struct {
+	Thread Link
+	Name   string
+	Cells  []Cell
+}
+
+type FunctionRow struct {
+	/* What the search box filters the row by */
+	Search string
+	Cells  []Cell
+	Name   Link
+	/* Where the function is defined, nil when the source is not available */
+	Source *Link
+	/* The call graph centred on the function */
+	CallGraph Link
+	/* Shown instead, when the source is not available */
+	Callers *NoteList
+}
+
+/* functions.html */
+type FunctionsPage struct {
+	Page
+	Start, Stop, Duration string
+	Timeline              bool
+	Threads               []ThreadOption
+	ThreadHeaders         []string
+	ThreadRows            []ThreadRow
+	Headers               []string
+	Functions             []FunctionRow
+}
+
+/* A mark on the minimap of a source page, of a severity class or "fn" */
+type MinimapMark struct {
+	Line  int
+	Class string
+	/* In percent of the page */
+	Top string
+}
+
+/* files/<source file>.html */
+type SourcePage struct {
+	Page
+	File    string
+	Headers []string
+	Lines   []SourceLine
+	Minimap []MinimapMark
+}
+
+type CallerRow struct {
+	Cells    []Cell
+	Function Link
+	At       Link
+}
+
+type CalleeRow struct {
+	Cells    []Cell
+	Function Link
+}
+
+/* functions/<function>.html */
+type FunctionPage struct {
+	Page
+	Name           string
+	DefinedAt      Link
+	CallGraph      Link
+	SummaryHeaders []string
+	Summary        []Cell
+	Callers        []CallerRow
+	UnknownCallers []Cell
+	Callees        []CalleeRow
+	SourceHeaders  []string
+	Source         []SourceLine
+	/* Links to the rest of a truncated source excerpt */
+	More *Link
+}
+
+type TimelineTick struct {
+	Left  int
+	Label string
+}
+
+type TimelineSlice struct {
+	Name, Title, Href string
+	Style             template.CSS
+}
+
+type TimelineLane struct {
+	Label  string
+	Height int
+	Slices []TimelineSlice
+}
+
+/* timeline.html */
+type TimelinePage struct {
+	Page
+	Activations int
+	Span        string
+	Ticks       []TimelineTick
+	Lanes       []TimelineLane
+}
+
+type CallGraphLevel struct {
+	Levels   int
+	Selected bool
+}
+
+/* callgraph.html */
+type CallGraphPage struct {
+	Page
+	Levels []CallGraphLevel
+	/* The graph as JSON, see callGraphData */
+	Data template.JS
+}
+
+/* The single file report, holding the others */
+type SinglePage struct {
+	Page
+	Csp  string
+	Css  template.CSS
+	Js   template.JS
+	Data template.JS
+}
+
+func newPage(title, root string, jsFiles []string, warnings []string) Page {
+	return Page{title, root, jsFiles, warnings}
+}
+
+func titledCell(title string, value interface{}) Cell {
+	return Cell{Title: title, Value: fmt.Sprint(value)}
+}
+
+/* Cells without a value get neither title nor class */
+func severityCell(title, class, value string) Cell {
+	if len(value) == 0 {
+		return Cell{}
+	}
+	return Cell{title, class, value}
+}
+
+func (nl *NoteList) add(hidden bool, note Note) {
+	note.Indent = nl.Indent
+	if hidden {
+		nl.Hidden = append(nl.Hidden, note)
+	} else {
+		nl.Notes = append(nl.Notes, note)
+	}
+}
+
+/*
+ * Renders the template name with data into page, a path relative to the
+ * report dir. The single file report keeps the page body instead.
+ */
+func (r *HtmlReporter) writePage(name, page string, data interface{}) {
+	if r.single != nil {
+		var b bytes.Buffer
+		r.render(&b, strings.TrimSuffix(name, ".html"), page, data)
+		r.single.addPage(r.prefix+page, b.String())
+		return
+	}
+	out := osutil.CreateFile(r.PathTo(page))
+	w := bufio.NewWriter(out)
+	r.render(w, name, page, data)
+	w.Flush()
+	if c, ok := out.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+func (r *HtmlReporter) render(w io.Writer, name, page string, data interface{}) {
+	if err := r.templates.ExecuteTemplate(w, name, data); err != nil {
+		log.Fatal("Rendering ", page, ": ", err)
+	}
+}
@@ -0,0 +1,169 @@
+package html
+
+import (
+	stdjson "encoding/json"
+	"path"
+	"sort"
+	"strings"
+)
+
+import "fprof/json"
+import "fprof/log"
+import "fprof/osutil"
+
+/* Source lines beyond this many are left out of the search index */
+var SearchIndexMaxLines = 200000
+
+/*
+ * What the search box of the pages looks through, loaded as a script so that
+ * it works from file:// URLs. Pages are given by their URL path in the report.
+ */
+type searchIndex struct {
+	/* [name, file, line, page] */
+	Functions [][]interface{} `json:"functions"`
+	/* [file, page] */
+	Files [][]string `json:"files"`
+	/* [index in Files, line, text] */
+	Lines [][]interface{} `json:"lines"`
+}
+
+const searchJs = `var fprofSearchTimer = null;
+function fprofTerms(query) {
+	return query.toLowerCase().split(/\s+/).filter(function(term) { return term; });
+}
+function fprofMatches(text, terms) {
+	text = text.toLowerCase();
+	for (var i = 0; i < terms.length; i++) {
+		if (text.indexOf(terms[i]) < 0) return false;
+	}
+	return true;
+}
+/* Shows the rows of the functions table that match */
+function fprofFilterFunctions(terms) {
+	$('#functions_table tbody tr').each(function() {
+		this.style.display = fprofMatches(this.getAttribute('data-search') || '', terms) ? '' : 'none';
+	});
+}
+function fprofSearchResult(text, target, detail) {
+	var div = document.createElement('div');
+	var a = document.createElement('a');
+	a.setAttribute('href', ($('#fprof_search').attr('data-root') || '') + target);
+	a.setAttribute('data-target', target);
+	a.textContent = text;
+	div.appendChild(a);
+	if (detail) {
+		var span = document.createElement('span');
+		span.className = 'detail';
+		span.textContent = ' ' + detail;
+		div.appendChild(span);
+	}
+	return div;
+}
+function fprofSearch(query) {
+	var terms = fprofTerms(query);
+	var results = document.getElementById('fprof_search_results');
+	var index = window.fprofSearchIndex;
+	fprofFilterFunctions(terms);
+	$(results).empty();
+	if (!terms.length || !index) {
+		results.style.display = 'none';
+		return;
+	}
+	var sections = [
+		['Functions', index.functions, 10, function(f) { return f[0] + ' ' + f[1]; },
+			function(f) { return fprofSearchResult(f[0], f[3], f[1] + ':' + f[2]); }],
+		['Files', index.files, 10, function(f) { return f[0]; },
+			function(f) { return fprofSearchResult(f[0], f[1]); }],
+		['Lines', index.lines, 20, function(l) { return l[2]; },
+			function(l) { var f = index.files[l[0]]; return fprofSearchResult(f[0] + ':' + l[1], f[1] + '#' + l[1], l[2]); }]
+	];
+	var found = false;
+	sections.forEach(function(section) {
+		var items = [], matches = 0;
+		for (var i = 0; i < section[1].length; i++) {
+			if (!fprofMatches(section[3](section[1][i]), terms)) continue;
+			if (matches < section[2]) items.push(section[4](section[1][i]));
+			matches++;
+		}
+		if (!matches) return;
+		found = true;
+		var title = document.createElement('div');
+		title.className = 'title';
+		title.textContent = section[0] + (matches > items.length ? ' (first ' + items.length + ' of ' + matches + ')' : '');
+		results.appendChild(title);
+		items.forEach(function(item) { results.appendChild(item); });
+	});
+	if (!found) $(results).text('No matches');
+	results.style.display = 'block';
+}
+$(document).on('input', '#fprof_search', function() {
+	var query = this.value;
+	clearTimeout(fprofSearchTimer);
+	fprofSearchTimer = setTimeout(function() { fprofSearch(query); }, 150);
+});
+$(document).on('keydown', '#fprof_search', function(e) {
+	if (e.keyCode == 27) {
+		this.value = '';
+		fprofSearch('');
+	} else if (e.keyCode == 13) {
+		var first = $('#fprof_search_results a').first();
+		if (first.length) first[0].click();
+	}
+});
+$(document).on('click', '#fprof_search_results a', function() {
+	$('#fprof_search_results').hide();
+});
+`
+
+func (r *HtmlReporter) newSearchIndex(functionCalls json.FunctionProfileSlice, exists map[string]bool) *searchIndex {
+	index := &searchIndex{[][]interface{}{}, [][]string{}, [][]interface{}{}}
+	for _, f := range functionCalls {
+		if f == nil {
+			continue
+		}
+		index.Functions = append(index.Functions, []interface{}{f.FullName(), f.Filename, f.StartLine, urlPath(r.functionPages.pages[f])})
+	}
+
+	files := []string{}
+	for file, exist := range exists {
+		if exist && !isEval(file) {
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+	left := 0
+	for i, file := range files {
+		index.Files = append(index.Files, []string{file, urlPath(path.Clean(r.htmlLineFilename(file)))})
+		osutil.ForEachLineInFile(file, func(lineNo int, text string) {
+			text = strings.TrimSpace(text)
+			switch {
+			case text == "":
+			case len(index.Lines) >= SearchIndexMaxLines:
+				left++
+			default:
+				index.Lines = append(index.Lines, []interface{}{i, lineNo, text})
+			}
+		})
+	}
+	if left > 0 {
+		log.Printf("%d source lines are left out of the search index\n", left)
+	}
+	return index
+}
+
+/* Writes js/search-index.js, or keeps the index for the single file report */
+func (r *HtmlReporter) GenerateSearchIndex(functionCalls json.FunctionProfileSlice, exists map[string]bool) {
+	if r.single != nil && r.prefix != "" {
+		return
+	}
+	index := r.newSearchIndex(functionCalls, exists)
+	if r.single != nil {
+		r.single.search = index
+		return
+	}
+	data, err := stdjson.Marshal(index)
+	if err != nil {
+		log.Fatal("Encoding the search index: ", err)
+	}
+	osutil.CreateFiles(map[string]string{r.PathTo("js/search-index.js"): "var fprofSearchIndex = " + string(data) + ";\n"})
+}
@@ -0,0 +1,350 @@
+package html
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	stdjson "encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+import "fprof/json"
+import "fprof/log"
+import "fprof/osutil"
+
+/*
+ * The single file report is one page holding the others: the bodies of the
+ * functions, function and timeline pages as rendered, and the source pages
+ * as data that the browser renders. Pages are told apart by their path in
+ * the multi page report, so that links between them work the same.
+ */
+type singleFile struct {
+	file    string
+	mu      sync.Mutex
+	pages   map[string]string
+	sources map[string]*compactSource
+	search  *searchIndex
+}
+
+/* A source page; lines are [code, cells, callers, calls made], see compactLine */
+type compactSource struct {
+	File    string          `json:"f"`
+	Headers []string        `json:"h"`
+	Lines   [][]interface{} `json:"l"`
+	/* The minimap as [line, class] */
+	Minimap [][]interface{} `json:"m"`
+}
+
+type singleData struct {
+	Legend  string                    `json:"legend"`
+	Pages   map[string]string         `json:"pages"`
+	Sources map[string]*compactSource `json:"sources"`
+	Search  *searchIndex              `json:"search"`
+}
+
+/* A reporter that writes the whole report into file */
+func NewSingleFile(file string) *HtmlReporter {
+	r := HtmlReporter{}
+	r.ReportDir = path.Dir(file)
+	r.single = &singleFile{
+		file:    file,
+		pages:   make(map[string]string),
+		sources: make(map[string]*compactSource),
+	}
+	return &r
+}
+
+func (sf *singleFile) addPage(page, body string) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.pages[path.Clean(page)] = body
+}
+
+func (sf *singleFile) addSource(page string, data *SourcePage) {
+	source := &compactSource{File: data.File, Headers: data.Headers, Lines: [][]interface{}{}, Minimap: [][]interface{}{}}
+	for i := range data.Lines {
+		source.Lines = append(source.Lines, compactLine(&data.Lines[i]))
+	}
+	for _, m := range data.Minimap {
+		source.Minimap = append(source.Minimap, []interface{}{m.Line, m.Class})
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.sources[path.Clean(page)] = source
+}
+
+func compactNote(n *Note) []interface{} {
+	note := []interface{}{n.Text, "", "", 0, n.After}
+	if n.Link != nil {
+		note[1], note[2] = n.Link.Href, n.Link.Text
+		if n.Link.Eval {
+			note[3] = 1
+		}
+	}
+	return note
+}
+
+/* [indent, notes, hidden notes] */
+func compactNotes(nl *NoteList) []interface{} {
+	notes, hidden := []interface{}{}, []interface{}{}
+	for i := range nl.Notes {
+		notes = append(notes, compactNote(&nl.Notes[i]))
+	}
+	for i := range nl.Hidden {
+		hidden = append(hidden, compactNote(&nl.Hidden[i]))
+	}
+	return []interface{}{nl.Indent, notes, hidden}
+}
+
+/* Highlighted code as its spans, plain text or [text, class] */
+func compactCode(l *SourceLine) interface{} {
+	if len(l.Spans) == 0 {
+		return l.Code
+	}
+	spans := []interface{}{}
+	for _, s := range l.Spans {
+		if s.Class == "" {
+			spans = append(spans, s.Text)
+		} else {
+			spans = append(spans, []string{s.Text, s.Class})
+		}
+	}
+	return spans
+}
+
+/*
+ * A line as [code, cells, callers, calls made], 0 for what the line has not
+ * and the trailing ones left out. Cells are their value, or [value, class].
+ */
+func compactLine(l *SourceLine) []interface{} {
+	line := []interface{}{compactCode(l), 0, 0, 0}
+	cells := []interface{}{}
+	empty := true
+	for _, c := range l.Cells {
+		switch {
+		case c.Class != "":
+			cells = append(cells, []string{c.Value, c.Class})
+		default:
+			cells = append(cells, c.Value)
+		}
+		empty = empty && c.Value == ""
+	}
+	if !empty {
+		line[1] = cells
+	}
+	if len(l.Callers) > 0 {
+		callers := []interface{}{}
+		for _, nl := range l.Callers {
+			callers = append(callers, compactNotes(nl))
+		}
+		line[2] = callers
+	}
+	if l.CallsMade != nil {
+		line[3] = compactNotes(l.CallsMade)
+	}
+	for len(line) > 1 && line[len(line)-1] == 0 {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+const singleJs = `var fprofData = JSON.parse(document.getElementById('fprof_data').textContent);
+var fprofSearchIndex = fprofData.search;
+/* The page shown, by its path in the multi page report */
+var fprofPage = null;
+fprofGo = function(href) {
+	var url = new URL(href, 'http://report/' + (fprofPage || 'functions.html'));
+	location.hash = '#' + url.pathname.substring(1) + url.hash;
+};
+fprofAnchor = function() {
+	var hash = location.hash.substring(1);
+	var i = hash.indexOf('#');
+	return i < 0 ? '' : decodeURIComponent(hash.substring(i + 1));
+};
+function fprofElement(tag, text, className) {
+	var el = document.createElement(tag);
+	if (text) el.textContent = text;
+	if (className) el.className = className;
+	return el;
+}
+function fprofNote(indent, n) {
+	var div = fprofElement('div', indent + '// ' + n[0], 'profile_note');
+	if (n[1]) {
+		var a = fprofElement('a', n[2]);
+		a.setAttribute('href', n[1]);
+		div.appendChild(a);
+	} else if (n[3]) {
+		var span = fprofElement('span');
+		span.title = 'Called from eval()';
+		span.appendChild(fprofElement('i', n[2]));
+		div.appendChild(span);
+	} else if (n[2]) {
+		div.appendChild(document.createTextNode(n[2]));
+	}
+	if (n[4]) div.appendChild(document.createTextNode(n[4]));
+	return div;
+}
+function fprofNotes(td, notes) {
+	var indent = notes[0];
+	for (var i = 0; i < notes[1].length; i++) td.appendChild(fprofNote(indent, notes[1][i]));
+	if (!notes[2].length) return;
+	var toggle = fprofElement('div', indent, 'toggleHide');
+	var a = fprofElement('a', 'Show ' + notes[2].length + ' more ...');
+	a.setAttribute('href', '#');
+	toggle.appendChild(a);
+	var hidden = fprofElement('div', '', 'hide');
+	for (var i = 0; i < notes[2].length; i++) hidden.appendChild(fprofNote(indent, notes[2][i]));
+	td.appendChild(toggle);
+	td.appendChild(hidden);
+}
+function fprofCode(td, code) {
+	if (typeof code == 'string') {
+		td.appendChild(document.createTextNode(code));
+		return;
+	}
+	for (var i = 0; i < code.length; i++) {
+		if (typeof code[i] == 'string') td.appendChild(document.createTextNode(code[i]));
+		else td.appendChild(fprofElement('span', code[i][0], code[i][1]));
+	}
+}
+function fprofSource(source) {
+	var page = document.createElement('div');
+	var left = page.appendChild(fprofElement('div', source.f, 'left'));
+	page.insertAdjacentHTML('beforeend', fprofData.legend);
+	if (source.m.length) {
+		left.appendChild(fprofElement('div', 'Keys: n/p next/previous hot line, f next function', 'keys'));
+		var map = page.appendChild(document.createElement('div'));
+		map.id = 'minimap';
+		source.m.forEach(function(m) {
+			var a = map.appendChild(fprofElement('a', '', m[1]));
+			a.setAttribute('href', '#' + m[0]);
+			a.setAttribute('data-line', m[0]);
+			a.title = 'Line ' + m[0];
+			a.style.top = ((m[0] - 1) * 100 / source.l.length) + '%';
+		});
+	}
+	var table = fprofElement('table', '', 'sortable clear');
+	table.id = 'function_table';
+	table.setAttribute('border', '1');
+	table.setAttribute('cellpadding', '0');
+	var tr = document.createElement('tr');
+	for (var i = 0; i < source.h.length; i++) tr.appendChild(fprofElement('th', source.h[i]));
+	var th = fprofElement('th', 'Code');
+	th.style.textAlign = 'left';
+	tr.appendChild(th);
+	table.appendChild(document.createElement('thead')).appendChild(tr);
+	var tbody = table.appendChild(document.createElement('tbody'));
+	for (var no = 1; no <= source.l.length; no++) {
+		var line = source.l[no - 1];
+		tr = tbody.appendChild(document.createElement('tr'));
+		var td = tr.appendChild(document.createElement('td'));
+		td.title = 'Line number';
+		td.appendChild(fprofElement('a', String(no))).id = String(no);
+		for (var i = 1; i < source.h.length; i++) {
+			var cell = line[1] ? line[1][i - 1] : '';
+			td = tr.appendChild(document.createElement('td'));
+			if (!cell) continue;
+			td.title = source.h[i];
+			if (typeof cell == 'string') {
+				td.textContent = cell;
+			} else {
+				td.textContent = cell[0];
+				td.className = cell[1];
+			}
+		}
+		td = tr.appendChild(fprofElement('td', '', 's'));
+		for (var i = 0; line[2] && i < line[2].length; i++) fprofNotes(td, line[2][i]);
+		fprofCode(td, line[0]);
+		if (line[3]) fprofNotes(td, line[3]);
+	}
+	page.appendChild(table);
+	page.appendChild(fprofElement('div', '', 'bottom_space'));
+	return page;
+}
+function fprofShow() {
+	var hash = location.hash.substring(1);
+	var i = hash.indexOf('#');
+	var page = (i < 0 ? hash : hash.substring(0, i)) || 'functions.html';
+	var anchor = i < 0 ? '' : decodeURIComponent(hash.substring(i + 1));
+	var view = $('#page');
+	if (page != fprofPage) {
+		var key = decodeURIComponent(page);
+		fprofPage = page;
+		if (fprofData.pages.hasOwnProperty(key)) {
+			view.html(fprofData.pages[key]);
+		} else if (fprofData.sources.hasOwnProperty(key)) {
+			view.empty().append(fprofSource(fprofData.sources[key]));
+		} else {
+			view.empty().append(fprofElement('div', 'This report has no page ' + key, 'warning'));
+		}
+		$('#functions_table').tablesorter({sortList: [[3,1]]});
+		$('#threads_table').tablesorter();
+		$('#function_table').tablesorter();
+		if ($('#timeline_view').length) initTimeline();
+		if ($('#callgraph').length) initCallgraph();
+		fprofFilterFunctions(fprofTerms($('#fprof_search').val() || ''));
+		window.scrollTo(0, 0);
+	}
+	var target = anchor && document.getElementById(anchor);
+	if (target) target.scrollIntoView();
+}
+$(document).on('click', '#page a[href]', function() {
+	var href = $(this).attr('href');
+	if (href == '#') return;
+	fprofGo(href);
+	return false;
+});
+/* Search results link pages by their path in the report */
+$(document).on('click', '#fprof_search_results a', function() {
+	location.hash = '#' + $(this).attr('data-target');
+	return false;
+});
+$(window).on('hashchange', fprofShow);
+$(document).ready(fprofShow);
+`
+
+/* The scripts of the single file report, in the order they run */
+var singleScripts = []string{"js/jquery-min.js", "js/jquery-tablesorter-min.js", "js/tablesorter.js", "js/fprof.js", "js/timeline.js", "js/search.js", "js/callgraph.js", "js/minimap.js"}
+
+var scriptEnd = regexp.MustCompile(`(?i)</(script)`)
+
+/* Writes the single file report of p, once all its pages are in */
+func (r *HtmlReporter) writeSingleFile(p *json.Profile) {
+	sources := jsSources()
+	scripts := []string{}
+	for _, file := range singleScripts {
+		scripts = append(scripts, sources[file])
+	}
+	scripts = append(scripts, singleJs)
+	/* Nothing may end the script element early */
+	js := scriptEnd.ReplaceAllString(strings.Join(scripts, "\n"), `<\/$1`)
+
+	var legend bytes.Buffer
+	r.render(&legend, "legend", "legend", nil)
+	data, err := stdjson.Marshal(&singleData{legend.String(), r.single.pages, r.single.sources, r.single.search})
+	if err != nil {
+		log.Fatal("Encoding ", r.single.file, ": ", err)
+	}
+
+	hash := sha256.Sum256([]byte(js))
+	page := SinglePage{
+		Page: newPage("fprof "+path.Base(r.single.file), "", nil, p.Warnings),
+		/* Inline styles are allowed anyway, for the timeline layout */
+		Csp: fmt.Sprintf("default-src 'none'; script-src 'sha256-%s'; style-src 'unsafe-inline'; img-src data:; base-uri 'none'; form-action 'none'", base64.StdEncoding.EncodeToString(hash[:])),
+		Css: template.CSS(cssSource()),
+		Js:  template.JS(js),
+		/* Marshal escapes <, > and &, so the data can not end its element */
+		Data: template.JS(data),
+	}
+	out := osutil.CreateFile(r.single.file)
+	r.render(out, "single.html", r.single.file, &page)
+	if c, ok := out.(io.Closer); ok {
+		c.Close()
+	}
+}
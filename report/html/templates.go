@@ -0,0 +1,234 @@
+package html
+
+import (
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+ * Pages load their scripts and styles from the report only, and have no
+ * inline scripts. Inline styles are allowed for the timeline layout.
+ */
+var ContentSecurityPolicy = "default-src 'none'; script-src 'self'; style-src 'self' 'unsafe-inline'; img-src data:; base-uri 'none'; form-action 'none'"
+
+/*
+ * The pages of the report are rendered from these templates, given the data
+ * models of page.go. Any of them can be replaced, and new ones added for the
+ * others to use, by a <name>.tmpl file in the HtmlReporter.Templates dir.
+ * "header" and "footer" are empty, they are there to be overridden and get
+ * rendered at the top and the bottom of every page. A page <name>.html puts
+ * its <name> body between "head" and "foot", the single file report puts
+ * the bodies of all pages into "single.html".
+ */
+var builtinTemplates = map[string]string{
+	"header": ``,
+	"footer": ``,
+
+	"head": `<html>
+ <head>
+  <meta charset="utf-8">
+  <meta http-equiv="Content-Security-Policy" content="{{csp}}">
+  <title>{{.Title}}</title>
+  <link rel="stylesheet" type="text/css" href="{{.Root}}css/style.css">
+{{- range .JsFiles}}
+  <script src="{{$.Root}}{{.}}" type="text/javascript"></script>
+{{- end}}
+ </head>
+ <body>
+{{template "header" .}}{{template "search" .}}`,
+
+	"search": `<div class="search"><input type="search" id="fprof_search" placeholder="Search functions, files and lines" autocomplete="off" data-root="{{.Root}}"><div id="fprof_search_results"></div></div>
+`,
+
+	"foot": `{{template "footer" .}}
+ </body>
+</html>
+`,
+
+	"warnings": `{{with .Warnings}}<div class="warning">
+<div>This report is incomplete:</div>
+{{range .}}<div>{{.}}</div>
+{{end}}</div>
+{{end}}`,
+
+	"legend": `<div class="legend">Severity:<table border="1" cellpadding="0"><tr>
+<td class="s_bad"> </td><td>Bad</td>
+<td class="s_high"> </td><td>High</td>
+<td class="s_medium"> </td><td>Medium</td>
+<td class="s_low"> </td><td>Low</td>
+</tr></table></div>
+`,
+
+	"cell": `<td{{with .Class}} class="{{.}}"{{end}}{{with .Title}} title="{{.}}"{{end}}>{{.Value}}</td>`,
+
+	"link": `{{if .Href}}<a href="{{.Href}}">{{.Text}}</a>{{else if .Eval}}<span title="Called from eval()"><i>{{.Text}}</i></span>{{else}}{{.Text}}{{end}}`,
+
+	"note": `<div class="profile_note">{{.Indent}}// {{.Text}}{{with .Link}}{{template "link" .}}{{end}}{{.After}}</div>`,
+
+	/* The hidden notes must follow the toggle right away, see toggleHide() */
+	"notes": `{{range .Notes}}{{template "note" .}}{{end}}
+{{- with .Hidden}}<div class="toggleHide">{{$.Indent}}<a href="#">Show {{len .}} more ...</a></div><div class="hide">{{range .}}{{template "note" .}}{{end}}</div>{{end}}`,
+
+	/* The code of a SourceLine, highlighted when it can be */
+	"code": `{{range .Spans}}{{if .Class}}<span class="{{.Class}}">{{.Text}}</span>{{else}}{{.Text}}{{end}}{{else}}{{.Code}}{{end}}`,
+
+	/* The marks link their line, the minimap.js keys find them by data-line */
+	"minimap": `{{with .}}<div id="minimap">{{range .}}<a class="{{.Class}}" href="#{{.Line}}" data-line="{{.Line}}" title="Line {{.Line}}" style="top:{{.Top}}%"></a>{{end}}</div>
+{{end}}`,
+
+	"threads": `{{with .Threads}}<div class="threads">Thread: <select>
+{{- range .}}<option value="{{.Value}}"{{if .Selected}} selected{{end}}>{{.Label}}</option>{{end -}}
+</select></div>
+{{end}}`,
+
+	"single.html": `<html>
+ <head>
+  <meta charset="utf-8">
+  <meta http-equiv="Content-Security-Policy" content="{{.Csp}}">
+  <title>{{.Title}}</title>
+  <style>{{.Css}}</style>
+  <script type="application/json" id="fprof_data">{{.Data}}</script>
+  <script>{{.Js}}</script>
+ </head>
+ <body>
+{{template "header" .}}{{template "search" .}}<div id="page"></div>
+{{template "foot" .}}`,
+
+	"functions.html": `{{template "head" .}}{{template "functions" .}}{{template "foot" .}}`,
+	"functions": `{{template "warnings" .}}{{template "threads" .}}<div class="left">
+<div>Start: {{.Start}}</div>
+<div>Stop: {{.Stop}}</div>
+<div>Duration: {{.Duration}}ms</div>
+{{if .Timeline}}<div><a href="timeline.html">Timeline</a></div>
+{{end}}<div><a href="callgraph.html">Call graph</a></div>
+</div>
+{{template "legend" .}}
+{{- if .ThreadRows}}<table id="threads_table" class="sortable clear" border="1" cellpadding="0">
+<thead><tr>{{range .ThreadHeaders}}<th>{{.}}</th>{{end}}</tr></thead>
+<tbody>
+{{range .ThreadRows}}<tr><td class="s">{{template "link" .Thread}}</td><td class="s">{{.Name}}</td>{{range .Cells}}{{template "cell" .}}{{end}}</tr>
+{{end}}</tbody>
+</table>
+{{end}}<table id="functions_table" class="sortable clear" border="1" cellpadding="0">
+<thead><tr>{{range .Headers}}<th>{{.}}</th>{{end}}<th style="text-align:left">Function</th></tr></thead>
+<tbody>
+{{range .Functions}}<tr data-search="{{.Search}}">{{range .Cells}}{{template "cell" .}}{{end}}<td class="s">{{with .Callers}}{{template "notes" .}}{{end}}{{template "link" .Name}}{{with .Source}} {{template "link" .}}{{end}} {{template "link" .CallGraph}}</td></tr>
+{{end}}</tbody>
+</table>
+`,
+
+	"source.html": `{{template "head" .}}{{template "source" .}}{{template "foot" .}}`,
+	"source": `{{template "warnings" .}}<div class="left">{{.File}}{{if .Minimap}}<div class="keys">Keys: n/p next/previous hot line, f next function</div>{{end}}</div>
+{{template "legend" .}}{{template "minimap" .Minimap}}<table id="function_table" class="sortable clear" border="1" cellpadding="0">
+<thead><tr>{{range .Headers}}<th>{{.}}</th>{{end}}<th style="text-align:left">Code</th></tr></thead>
+<tbody>
+{{range .Lines}}<tr><td title="Line number"><a id="{{.No}}">{{.No}}</a></td>{{range .Cells}}{{template "cell" .}}{{end}}<td class="s">{{range .Callers}}{{template "notes" .}}{{end}}{{template "code" .}}{{with .CallsMade}}{{template "notes" .}}{{end}}</td></tr>
+{{end}}</tbody>
+</table>
+<div class="bottom_space"></div>
+`,
+
+	"function.html": `{{template "head" .}}{{template "function" .}}{{template "foot" .}}`,
+	"function": `{{template "warnings" .}}<div class="left">
+<div><a href="{{.Root}}functions.html">Functions</a> {{template "link" .CallGraph}}</div>
+<h2>{{.Name}}</h2>
+<div>Defined at {{template "link" .DefinedAt}}</div>
+</div>
+{{template "legend" .}}<div class="clear">
+<table border="1" cellpadding="0">
+<thead><tr>{{range .SummaryHeaders}}<th>{{.}}</th>{{end}}</tr></thead>
+<tbody><tr>{{range .Summary}}{{template "cell" .}}{{end}}</tr></tbody>
+</table>
+<h3>Callers</h3>
+<table border="1" cellpadding="0">
+<thead><tr><th>Calls</th><th>Time (ms)</th><th>Avg (ms/call)</th><th style="text-align:left">Caller</th><th style="text-align:left">Called at</th></tr></thead>
+<tbody>
+{{range .Callers}}<tr>{{range .Cells}}{{template "cell" .}}{{end}}<td class="s">{{template "link" .Function}}</td><td class="s">{{template "link" .At}}</td></tr>
+{{end}}{{with .UnknownCallers}}<tr>{{range .}}{{template "cell" .}}{{end}}<td class="s"><i>Unknown callers</i></td><td></td></tr>
+{{end}}</tbody>
+</table>
+<h3>Callees</h3>
+{{if .Callees}}<table border="1" cellpadding="0">
+<thead><tr><th>Calls</th><th>Time (ms)</th><th>Avg (ms/call)</th><th style="text-align:left">Callee</th></tr></thead>
+<tbody>
+{{range .Callees}}<tr>{{range .Cells}}{{template "cell" .}}{{end}}<td class="s">{{template "link" .Function}}</td></tr>
+{{end}}</tbody>
+</table>
+{{else}}<div>None</div>
+{{end}}{{if .Source}}<h3>Source</h3>
+<table border="1" cellpadding="0">
+<thead><tr>{{range .SourceHeaders}}<th>{{.}}</th>{{end}}<th style="text-align:left">Code</th></tr></thead>
+<tbody>
+{{range .Source}}<tr><td title="Line number">{{template "link" .Link}}</td>{{range .Cells}}{{template "cell" .}}{{end}}<td class="s">{{template "code" .}}</td></tr>
+{{end}}</tbody>
+</table>
+{{with .More}}<div>{{template "link" .}}</div>
+{{end}}{{end}}</div>
+`,
+
+	"timeline.html": `{{template "head" .}}{{template "timeline" .}}{{template "foot" .}}`,
+	"timeline": `{{template "warnings" .}}<div class="left">
+<div><a href="functions.html">Functions</a></div>
+<div>Timeline of {{.Activations}} activations over {{.Span}}ms</div>
+</div>
+<div class="legend">Zoom: <a href="#" class="zoom" data-zoom="0.5">-</a> <span id="timeline_zoom">1x</span> <a href="#" class="zoom" data-zoom="2">+</a> (Ctrl+wheel)</div>
+<div id="timeline_view" class="clear"><div id="timeline">
+<div class="axis">{{range .Ticks}}<span class="tick" style="left:{{.Left}}%">+{{.Label}}ms</span>{{end}}</div>
+{{range .Lanes}}<div>{{.Label}}</div>
+<div class="lane" style="height:{{.Height}}px">
+{{range .Slices}}{{if .Href}}<a class="slice" href="{{.Href}}" title="{{.Title}}" style="{{.Style}}">{{.Name}}</a>{{else}}<span class="slice" title="{{.Title}}" style="{{.Style}}">{{.Name}}</span>{{end}}
+{{end}}</div>
+{{end}}</div></div>
+`,
+
+	"callgraph.html": `{{template "head" .}}{{template "callgraph" .}}{{template "foot" .}}`,
+	"callgraph": `{{template "warnings" .}}<div class="left">
+<div><a href="functions.html">Functions</a></div>
+<h2 id="callgraph_title"></h2>
+<div id="callgraph_links"></div>
+</div>
+{{template "legend" .}}<div class="legend clear">Levels: <select id="callgraph_levels">
+{{- range .Levels}}<option value="{{.Levels}}"{{if .Selected}} selected{{end}}>{{.Levels}}</option>{{end -}}
+</select> Colour by self time, edge width by time, click a function to centre on it</div>
+<script type="application/json" id="fprof_callgraph">{{.Data}}</script>
+<div id="callgraph" class="clear"></div>
+`,
+}
+
+/*
+ * Parses the built-in templates, then those of dir if given. The name of a
+ * template file without its .tmpl extension is the template it replaces.
+ */
+func loadTemplates(dir string) (*template.Template, error) {
+	names := []string{}
+	for name := range builtinTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	t := template.New("fprof").Funcs(template.FuncMap{
+		"csp": func() string { return ContentSecurityPolicy },
+	})
+	for _, name := range names {
+		template.Must(t.New(name).Parse(builtinTemplates[name]))
+	}
+	if dir == "" {
+		return t, nil
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		text, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := t.New(strings.TrimSuffix(filepath.Base(file), ".tmpl")).Parse(string(text)); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
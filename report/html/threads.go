@@ -0,0 +1,127 @@
+package html
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+import "fprof/report"
+import "fprof/json"
+
+const ThreadsDir = "threads"
+
+/*
+ * Links the functions page of the combined report with those of the per
+ * thread reports, which are complete reports of their own in ThreadsDir.
+ */
+type threadSelector struct {
+	ids   []string
+	names map[string]string
+	/* The report dir of each thread, see threadDirs */
+	dirs map[string]string
+	/* The thread of the report, "" for the combined one */
+	current string
+	/* Relative path from the report to the combined report */
+	root string
+}
+
+/* Thread ids may be anything, keep them from escaping the report dir */
+func threadDir(id string) string {
+	return ThreadsDir + "/" + strings.Map(func(ch rune) rune {
+		if ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '-' || ch == '_' {
+			return ch
+		}
+		return '_'
+	}, id)
+}
+
+/*
+ * The report dir of each of ids. Ids that clean up to the same dir, ignoring
+ * case, get a number appended in the order of ids like function pages do.
+ */
+func threadDirs(ids []string) map[string]string {
+	dirs := make(map[string]string)
+	used := make(map[string]bool)
+	for _, id := range ids {
+		base := threadDir(id)
+		dir := base
+		for n := 2; used[strings.ToLower(dir)]; n++ {
+			dir = fmt.Sprintf("%s-%d", base, n)
+		}
+		used[strings.ToLower(dir)] = true
+		dirs[id] = dir
+	}
+	return dirs
+}
+
+func newThreadSelector(p *json.Profile) *threadSelector {
+	ts := threadSelector{names: make(map[string]string)}
+	ts.ids = p.ThreadIds()
+	ts.dirs = threadDirs(ts.ids)
+	for id, t := range p.Threads {
+		ts.names[id] = t.Name
+	}
+	return &ts
+}
+
+func (ts *threadSelector) label(id string) string {
+	if name := ts.names[id]; name != "" {
+		return fmt.Sprintf("Thread %s (%s)", id, name)
+	}
+	return "Thread " + id
+}
+
+/* The choices of the thread selector, relative to the report root */
+func (ts *threadSelector) options() []ThreadOption {
+	options := []ThreadOption{{ts.root + "functions.html", "All threads", ts.current == ""}}
+	for _, id := range ts.ids {
+		options = append(options, ThreadOption{ts.root + ts.dirs[id] + "/functions.html", ts.label(id), ts.current == id})
+	}
+	return options
+}
+
+var threadTableHeaders = []string{"Thread", "Name", "Duration ms", "Lines", "Hits", "Functions", "Self ms", "Self %"}
+
+/* The rows of the per-thread summary table of the combined functions page */
+func (ts *threadSelector) rows(p *json.Profile) []ThreadRow {
+	rows := []ThreadRow{}
+	for _, s := range report.GetThreadSummaries(p) {
+		rows = append(rows, ThreadRow{
+			Thread: Link{Href: ts.dirs[s.Id] + "/functions.html", Text: s.Id},
+			Name:   s.Name,
+			Cells: []Cell{
+				titledCell(threadTableHeaders[2], s.Duration.NonZeroMsOrNone()),
+				titledCell(threadTableHeaders[3], s.Lines),
+				titledCell(threadTableHeaders[4], s.Hits),
+				titledCell(threadTableHeaders[5], s.Functions),
+				titledCell(threadTableHeaders[6], s.Self.NonZeroMsOrNone()),
+				titledCell(threadTableHeaders[7], fmt.Sprintf("%3.1f", s.Share)),
+			},
+		})
+	}
+	return rows
+}
+
+/* Generates a complete report for each thread */
+func (r *HtmlReporter) reportThreads(p *json.Profile) {
+	for _, id := range r.threads.ids {
+		dir := r.threads.dirs[id]
+		var tr *HtmlReporter
+		if r.single != nil {
+			tr = &HtmlReporter{single: r.single, prefix: r.prefix + dir + "/"}
+		} else {
+			tr = New(path.Join(r.ReportDir, dir))
+		}
+		tr.Templates = r.Templates
+		tr.templates = r.templates
+		tr.threads = &threadSelector{
+			ids:     r.threads.ids,
+			names:   r.threads.names,
+			dirs:    r.threads.dirs,
+			current: id,
+			root:    pathToRoot(dir + "/functions.html"),
+		}
+		tr.ReportFunctions(p.Thread(id))
+	}
+}
@@ -0,0 +1,171 @@
+package html
+
+import (
+	"fmt"
+	"hash/fnv"
+	"html/template"
+	"path"
+	"sort"
+)
+
+import "fprof/json"
+
+/* Activations beyond this many are left out of the timeline, shortest first */
+var TimelineMaxSlices = 20000
+
+const timelineRowPx = 18
+
+const timelineJs = `var timelineZoom = 1;
+function zoomTimeline(factor, clientX) {
+	var view = document.getElementById('timeline_view');
+	var timeline = document.getElementById('timeline');
+	var x = clientX === undefined ? view.clientWidth / 2 : clientX - view.getBoundingClientRect().left;
+	var at = (view.scrollLeft + x) / timeline.offsetWidth;
+	timelineZoom = Math.min(Math.max(timelineZoom * factor, 1), 10000);
+	timeline.style.width = (timelineZoom * 100) + '%';
+	view.scrollLeft = at * timeline.offsetWidth - x;
+	document.getElementById('timeline_zoom').innerHTML = Math.round(timelineZoom * 10) / 10 + 'x';
+}
+$(document).on('click', 'a.zoom', function() {
+	zoomTimeline(parseFloat($(this).attr('data-zoom')));
+	return false;
+});
+/* Wheel zooming can not be delegated, browsers make such listeners passive */
+function initTimeline() {
+	timelineZoom = 1;
+	$('#timeline_view').on('wheel', function(e) {
+		if (!e.ctrlKey) return;
+		e.preventDefault();
+		zoomTimeline(e.originalEvent.deltaY < 0 ? 1.25 : 0.8, e.originalEvent.clientX);
+	});
+}
+$(document).ready(initTimeline);
+`
+
+/* A stable colour per function, so its activations are easy to follow */
+func sliceColor(name string) string {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return fmt.Sprintf("hsl(%d,60%%,75%%)", h.Sum32()%360)
+}
+
+func (r *HtmlReporter) threadLabel(p *json.Profile, id string) string {
+	name := ""
+	if r.threads != nil {
+		name = r.threads.names[id]
+	} else if t := p.Threads[id]; t != nil {
+		name = t.Name
+	}
+	switch {
+	case id == "":
+		return "Main thread"
+	case name != "":
+		return fmt.Sprintf("Thread %s (%s)", id, name)
+	}
+	return "Thread " + id
+}
+
+/* Keeps the longest activations when there are too many to show */
+func limitActivations(activations []*json.Activation) ([]*json.Activation, int) {
+	if TimelineMaxSlices <= 0 || len(activations) <= TimelineMaxSlices {
+		return activations, 0
+	}
+	kept := make([]*json.Activation, len(activations))
+	copy(kept, activations)
+	sort.SliceStable(kept, func(i, j int) bool {
+		di, dj := kept[i].Duration(), kept[j].Duration()
+		return dj.IsLessThan(&di)
+	})
+	kept = kept[:TimelineMaxSlices]
+	sort.SliceStable(kept, func(i, j int) bool {
+		return kept[i].Start.IsLessThan(&kept[j].Start)
+	})
+	return kept, len(activations) - TimelineMaxSlices
+}
+
+func (r *HtmlReporter) timelineSlice(a *json.Activation, start int64, span float64, exists map[string]bool) TimelineSlice {
+	left := float64(a.Start.InNanoseconds()-start) * 100 / span
+	width := float64(a.Duration().InNanoseconds()) * 100 / span
+	d := a.Duration()
+	slice := TimelineSlice{
+		Name: a.FullName(),
+		Title: fmt.Sprintf("%s %sms at +%sms", a.FullName(), d.InMillisecondsStr(),
+			json.NanosecondsToTimeSpec(a.Start.InNanoseconds()-start).InMillisecondsStr()),
+		/* Made of numbers and the colour only, safe as it is */
+		Style: template.CSS(fmt.Sprintf("left:%.4f%%;width:%.4f%%;top:%dpx;background:%s",
+			left, width, a.Depth*timelineRowPx, sliceColor(a.FullName()))),
+	}
+	if exists[a.Filename] && !isEval(a.Filename) {
+		slice.Href = urlPath(path.Clean(r.htmlLineFilename(a.Filename)))
+		if a.Line > 0 {
+			slice.Href += fmt.Sprintf("#%d", a.Line)
+		}
+	}
+	return slice
+}
+
+/*
+ * Writes timeline.html: a lane per thread with the function activations of
+ * the event log laid out over wall time, nested calls below their callers.
+ */
+func (r *HtmlReporter) GenerateTimelineHtmlFile(p *json.Profile, jsFiles []string, exists map[string]bool) {
+	activations, warnings := p.Activations()
+	activations, left := limitActivations(activations)
+	if left > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d of the shortest activations are left out", left))
+	}
+
+	var start, stop int64
+	lanes := make(map[string][]*json.Activation)
+	threads := []string{}
+	for i, a := range activations {
+		if i == 0 || a.Start.InNanoseconds() < start {
+			start = a.Start.InNanoseconds()
+		}
+		if a.Stop.InNanoseconds() > stop {
+			stop = a.Stop.InNanoseconds()
+		}
+		if _, ok := lanes[a.Thread]; !ok {
+			threads = append(threads, a.Thread)
+		}
+		lanes[a.Thread] = append(lanes[a.Thread], a)
+	}
+	/* Profiles from other profilers may not have real start and stop times */
+	if p.Start != (json.TimeSpec{}) {
+		if p.Start.InNanoseconds() < start || len(activations) == 0 {
+			start = p.Start.InNanoseconds()
+		}
+		if p.Stop.InNanoseconds() > stop {
+			stop = p.Stop.InNanoseconds()
+		}
+	}
+	span := float64(stop - start)
+	if span <= 0 {
+		span = 1
+	}
+
+	data := TimelinePage{
+		Page: newPage("Timeline", "", append(append([]string{}, jsFiles...), "js/timeline.js"),
+			append(append([]string{}, p.Warnings...), warnings...)),
+		Activations: len(activations),
+		Span:        json.NanosecondsToTimeSpec(stop - start).InMillisecondsStr(),
+	}
+	for i := 0; i <= 10; i++ {
+		at := json.NanosecondsToTimeSpec(int64(span) * int64(i) / 10)
+		data.Ticks = append(data.Ticks, TimelineTick{i * 10, at.InMillisecondsStr()})
+	}
+	for _, thread := range threads {
+		depth := 0
+		for _, a := range lanes[thread] {
+			if a.Depth > depth {
+				depth = a.Depth
+			}
+		}
+		lane := TimelineLane{Label: r.threadLabel(p, thread), Height: (depth + 1) * timelineRowPx}
+		for _, a := range lanes[thread] {
+			lane.Slices = append(lane.Slices, r.timelineSlice(a, start, span, exists))
+		}
+		data.Lanes = append(data.Lanes, lane)
+	}
+	r.writePage("timeline.html", "timeline.html", &data)
+}
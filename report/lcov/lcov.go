@@ -0,0 +1,93 @@
+package lcov
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+import "fprof/json"
+import "fprof/osutil"
+import "fprof/report"
+
+/*
+ * LcovReporter writes the line hit counts of a profile as an LCOV tracefile
+ * so coverage tools like genhtml can use profiling runs. Source lines without
+ * a line profile are reported as not executed.
+ */
+type LcovReporter struct {
+	w        io.Writer
+	TestName string
+}
+
+func New(w io.Writer) *LcovReporter {
+	return &LcovReporter{w, ""}
+}
+
+func (r *LcovReporter) writeFile(file string, lineProfiles []*json.LineProfile, functions json.FunctionProfileSlice) {
+	fmt.Fprintf(r.w, "TN:%s\n", r.TestName)
+	fmt.Fprintf(r.w, "SF:%s\n", file)
+
+	sort.Sort(byStartLine(functions))
+	hit := 0
+	for _, f := range functions {
+		fmt.Fprintf(r.w, "FN:%d,%s\n", f.StartLine, f.FullName())
+	}
+	for _, f := range functions {
+		fmt.Fprintf(r.w, "FNDA:%d,%s\n", f.Hits, f.FullName())
+		if f.Hits > 0 {
+			hit++
+		}
+	}
+	fmt.Fprintf(r.w, "FNF:%d\n", len(functions))
+	fmt.Fprintf(r.w, "FNH:%d\n", hit)
+
+	nLines := len(lineProfiles)
+	if osutil.FileExists(file) {
+		if n := osutil.CountLine(file); n > nLines {
+			nLines = n
+		}
+	}
+	hit = 0
+	for line := 1; line <= nLines; line++ {
+		var hits json.Counter
+		if line <= len(lineProfiles) && lineProfiles[line-1] != nil {
+			hits = lineProfiles[line-1].Hits
+		}
+		fmt.Fprintf(r.w, "DA:%d,%d\n", line, hits)
+		if hits > 0 {
+			hit++
+		}
+	}
+	fmt.Fprintf(r.w, "LF:%d\n", nLines)
+	fmt.Fprintf(r.w, "LH:%d\n", hit)
+	fmt.Fprintln(r.w, "end_of_record")
+}
+
+func (r *LcovReporter) ReportFunctions(p *json.Profile) {
+	functionsIn := make(map[string]json.FunctionProfileSlice)
+	for _, f := range p.FileProfileMap.GetFunctionsSortedByExlusiveTime() {
+		if f == nil || f.IsNative || f.Filename == "" {
+			continue
+		}
+		functionsIn[f.Filename] = append(functionsIn[f.Filename], f)
+	}
+
+	files := report.SortedFilenames(p.FileProfileMap)
+	for file := range functionsIn {
+		if _, ok := p.FileProfileMap[file]; !ok && osutil.FileExists(file) {
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		r.writeFile(file, p.FileProfileMap[file], functionsIn[file])
+	}
+}
+
+type byStartLine json.FunctionProfileSlice
+
+func (p byStartLine) Len() int           { return len(p) }
+func (p byStartLine) Less(i, j int) bool { return p[i].StartLine < p[j].StartLine }
+func (p byStartLine) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
@@ -0,0 +1,59 @@
+package lcov
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+import "fprof/json"
+
+func TestReportFunctions(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "a.fe")
+	source := "function f() {\n\treturn 1;\n}\n\nf();\n"
+	if err := os.WriteFile(file, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := json.DecodeFromBytes([]byte(`{
+	"files": {
+		"` + file + `": [
+			{
+				"functions": [
+					{ "name": "f", "start_line": 1, "hits": 1 },
+					{ "name": "unused", "start_line": 4, "hits": 0 },
+					{ "name": "println", "namespace": "Console", "is_native": true, "hits": 1 }
+				],
+				"hits": 1,
+				"total_duration": { "sec": 0, "nsec": 1000 }
+			},
+			{ "hits": 1, "total_duration": { "sec": 0, "nsec": 1000 } },
+			null,
+			{ "hits": 0, "total_duration": { "sec": 0, "nsec": 0 } },
+			{ "hits": 1, "total_duration": { "sec": 0, "nsec": 1000 } }
+		]
+	}
+}`))
+
+	var b bytes.Buffer
+	New(&b).ReportFunctions(p)
+	expected := "TN:\n" +
+		"SF:" + file + "\n" +
+		"FN:1,f\n" +
+		"FN:4,unused\n" +
+		"FNDA:1,f\n" +
+		"FNDA:0,unused\n" +
+		"FNF:2\n" +
+		"FNH:1\n" +
+		"DA:1,1\n" +
+		"DA:2,1\n" +
+		"DA:3,0\n" +
+		"DA:4,0\n" +
+		"DA:5,1\n" +
+		"LF:5\n" +
+		"LH:3\n" +
+		"end_of_record\n"
+	if b.String() != expected {
+		t.Errorf("Got:\n%s\nExpected:\n%s", b.String(), expected)
+	}
+}
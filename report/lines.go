@@ -0,0 +1,55 @@
+package report
+
+import (
+	"fprof/json"
+	"sort"
+)
+
+/* A profiled line of a source file */
+type LineRef struct {
+	File    string
+	Line    int
+	Profile *json.LineProfile
+	/* Severity class of the time on line, relative to the rest of the file */
+	Severity string
+}
+
+/*
+ * GetLines returns every profiled line, sorted by descending time on line.
+ * The severity is judged per file, the same as the html source pages do.
+ */
+func GetLines(fileProfiles json.FileProfile) LineRefSlice {
+	lines := LineRefSlice{}
+	for _, file := range SortedFilenames(fileProfiles) {
+		lineProfiles := fileProfiles[file]
+		ownTimeStats, _ := GetLineMADStats(lineProfiles)
+		for i, lp := range lineProfiles {
+			if lp == nil {
+				continue
+			}
+			severity := GetSeverityClass(lp.OwnTime().InMilliseconds(), ownTimeStats)
+			lines = append(lines, &LineRef{file, i + 1, lp, severity})
+		}
+	}
+	sort.Stable(lines)
+	return lines
+}
+
+/* Sorts descending by time on line */
+type LineRefSlice []*LineRef
+
+func (p LineRefSlice) Len() int { return len(p) }
+func (p LineRefSlice) Less(j, i int) bool {
+	ti, tj := p[i].Profile.OwnTime(), p[j].Profile.OwnTime()
+	return ti.IsLessThan(&tj)
+}
+func (p LineRefSlice) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+/* Sorts descending by the whole cost of a line, its own time and the time in the functions it called */
+type LineRefsByTotalTime []*LineRef
+
+func (p LineRefsByTotalTime) Len() int { return len(p) }
+func (p LineRefsByTotalTime) Less(j, i int) bool {
+	return p[i].Profile.TotalDuration.IsLessThan(&p[j].Profile.TotalDuration)
+}
+func (p LineRefsByTotalTime) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
@@ -0,0 +1,319 @@
+package markdown
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+import "fprof/json"
+import "fprof/log"
+import "fprof/report"
+
+var severityNames = map[string]string{
+	"s_low":    "Low",
+	"s_medium": "Medium",
+	"s_high":   "High",
+	"s_bad":    "Bad",
+}
+
+/*
+ * MarkdownReporter writes a compact summary of a profile, meant to be pasted
+ * into merge request comments. With a Base profile the tables get delta
+ * columns against it. When the summary does not fit in MaxLength bytes the
+ * row counts are halved until it does, and when even the header does not
+ * fit, it is cut after the last line that does.
+ */
+type MarkdownReporter struct {
+	w         io.Writer
+	Functions int
+	Lines     int
+	Worst     int
+	MaxLength int
+	Base      *json.Profile
+}
+
+func New(w io.Writer) *MarkdownReporter {
+	return &MarkdownReporter{w, 10, 10, 10, 65536, nil}
+}
+
+/* Escapes text for use inside a table cell */
+func cell(s string) string {
+	s = strings.Replace(s, "&", "&amp;", -1)
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "|", `\|`, -1)
+	s = strings.Replace(s, "`", "\\`", -1)
+	s = strings.Replace(s, "*", `\*`, -1)
+	s = strings.Replace(s, "_", `\_`, -1)
+	s = strings.Replace(s, "<", "&lt;", -1)
+	return s
+}
+
+func location(file string, line int) string {
+	return cell(fmt.Sprintf("%s:%d", file, line))
+}
+
+func delta(v, base float64, found bool) string {
+	if !found {
+		return "new"
+	}
+	return fmt.Sprintf("%+.3f", v-base)
+}
+
+func share(ms, totalMs float64) string {
+	if totalMs <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.1f%%", ms*100/totalMs)
+}
+
+type summary struct {
+	p            *json.Profile
+	functions    json.FunctionProfileSlice
+	lines        report.LineRefSlice
+	baseFunction map[string]*json.FunctionProfile
+	baseLine     map[string]*json.LineProfile
+}
+
+func functionKey(f *json.FunctionProfile) string {
+	return f.Filename + "\x00" + f.FullName()
+}
+
+func lineKey(file string, line int) string {
+	return fmt.Sprintf("%s:%d", file, line)
+}
+
+func newSummary(p, base *json.Profile) *summary {
+	s := &summary{p: p}
+	for _, f := range p.FileProfileMap.GetFunctionsSortedByExlusiveTime() {
+		if f != nil {
+			s.functions = append(s.functions, f)
+		}
+	}
+	s.lines = report.GetLines(p.FileProfileMap)
+	if base == nil {
+		return s
+	}
+	s.baseFunction = make(map[string]*json.FunctionProfile)
+	for _, f := range base.FileProfileMap.GetFunctionsSortedByExlusiveTime() {
+		if f != nil {
+			s.baseFunction[functionKey(f)] = f
+		}
+	}
+	s.baseLine = make(map[string]*json.LineProfile)
+	for _, l := range report.GetLines(base.FileProfileMap) {
+		s.baseLine[lineKey(l.File, l.Line)] = l.Profile
+	}
+	return s
+}
+
+func (s *summary) isDiff() bool { return s.baseFunction != nil }
+
+func (s *summary) writeHeader(w io.Writer, base *json.Profile) {
+	fmt.Fprintln(w, "### fprof profile summary")
+	fmt.Fprintln(w)
+	if s.isDiff() {
+		fmt.Fprintln(w, "| | Profile | Base | Δ |")
+		fmt.Fprintln(w, "|---|---|---|---|")
+		fmt.Fprintf(w, "| Start | %s | %s | |\n", s.p.Start.Time(), base.Start.Time())
+		fmt.Fprintf(w, "| Stop | %s | %s | |\n", s.p.Stop.Time(), base.Stop.Time())
+		fmt.Fprintf(w, "| Duration (ms) | %s | %s | %s |\n",
+			s.p.Duration.InMillisecondsStr(), base.Duration.InMillisecondsStr(),
+			delta(s.p.Duration.InMilliseconds(), base.Duration.InMilliseconds(), true))
+	} else {
+		fmt.Fprintln(w, "| Start | Stop | Duration (ms) |")
+		fmt.Fprintln(w, "|---|---|---:|")
+		fmt.Fprintf(w, "| %s | %s | %s |\n", s.p.Start.Time(), s.p.Stop.Time(), s.p.Duration.InMillisecondsStr())
+	}
+	fmt.Fprintln(w)
+}
+
+/* Custom metric columns, between the time columns and the deltas */
+func (s *summary) writeMetricHeaders(w io.Writer) {
+	for i := range s.p.Metrics {
+		fmt.Fprintf(w, " %s |", cell(s.p.Metrics[i].Title()))
+	}
+}
+
+func (s *summary) writeMetricAlignments(w io.Writer) {
+	for range s.p.Metrics {
+		fmt.Fprint(w, "---:|")
+	}
+}
+
+func (s *summary) writeMetrics(w io.Writer, metrics json.Metrics) {
+	for _, m := range s.p.Metrics {
+		fmt.Fprintf(w, " %s |", report.MetricValueOrNone(metrics, m.Name))
+	}
+}
+
+func (s *summary) writeFunctions(w io.Writer, n int) {
+	if n <= 0 || len(s.functions) == 0 {
+		return
+	}
+	fth := report.FunctionTableHeaders
+	totalMs := s.p.Duration.InMilliseconds()
+	if n > len(s.functions) {
+		n = len(s.functions)
+	}
+	fmt.Fprintf(w, "#### Top %d functions\n\n", n)
+	fmt.Fprintf(w, "| %s | %s | %s | Share | %s | Function | Location |", fth.Calls, fth.SelfMs, fth.InclusiveMs, fth.Ratio)
+	s.writeMetricHeaders(w)
+	if s.isDiff() {
+		fmt.Fprint(w, " Δ Calls | Δ Self (ms) | Δ Inclusive (ms) |")
+	}
+	fmt.Fprintln(w)
+	fmt.Fprint(w, "|---:|---:|---:|---:|---:|---|---|")
+	s.writeMetricAlignments(w)
+	if s.isDiff() {
+		fmt.Fprint(w, "---:|---:|---:|")
+	}
+	fmt.Fprintln(w)
+	for i, f := range s.functions {
+		if i == n {
+			break
+		}
+		fmt.Fprintf(w, "| %d | %s | %s | %s | %.1f | %s | %s |",
+			f.Hits, f.OwnTime.InMillisecondsStr(), f.InclusiveDuration.InMillisecondsStr(),
+			share(f.OwnTime.InMilliseconds(), totalMs), f.OwnTimeRatio(),
+			cell(f.FullName()), location(f.Filename, int(f.StartLine)))
+		s.writeMetrics(w, f.Metrics)
+		if s.isDiff() {
+			b, found := s.baseFunction[functionKey(f)]
+			if !found {
+				b = &json.FunctionProfile{}
+			}
+			calls := "new"
+			if found {
+				calls = fmt.Sprintf("%+d", int64(f.Hits)-int64(b.Hits))
+			}
+			fmt.Fprintf(w, " %s | %s | %s |", calls,
+				delta(f.OwnTime.InMilliseconds(), b.OwnTime.InMilliseconds(), found),
+				delta(f.InclusiveDuration.InMilliseconds(), b.InclusiveDuration.InMilliseconds(), found))
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w)
+}
+
+func (s *summary) writeLines(w io.Writer, n int) {
+	if n <= 0 || len(s.lines) == 0 {
+		return
+	}
+	cth := report.CodeTableHeaders
+	if n > len(s.lines) {
+		n = len(s.lines)
+	}
+	fmt.Fprintf(w, "#### Top %d lines\n\n", n)
+	fmt.Fprintf(w, "| Location | %s | %s | %s | %s |", cth.Hits, cth.TimeOnLine, cth.CallsMade, cth.TimeInFunctions)
+	s.writeMetricHeaders(w)
+	if s.isDiff() {
+		fmt.Fprint(w, " Δ Hits | Δ Time on line (ms) |")
+	}
+	fmt.Fprintln(w)
+	fmt.Fprint(w, "|---|---:|---:|---:|---:|")
+	s.writeMetricAlignments(w)
+	if s.isDiff() {
+		fmt.Fprint(w, "---:|---:|")
+	}
+	fmt.Fprintln(w)
+	for i, l := range s.lines {
+		if i == n {
+			break
+		}
+		lp := l.Profile
+		fmt.Fprintf(w, "| %s | %d | %s | %d | %s |",
+			location(l.File, l.Line), lp.Hits, lp.OwnTime().InMillisecondsStr(),
+			lp.CallsMade, lp.TimeInFunctions.InMillisecondsStr())
+		s.writeMetrics(w, lp.Metrics)
+		if s.isDiff() {
+			b, found := s.baseLine[lineKey(l.File, l.Line)]
+			hits := "new"
+			if found {
+				hits = fmt.Sprintf("%+d", int64(lp.Hits)-int64(b.Hits))
+			} else {
+				b = &json.LineProfile{}
+			}
+			fmt.Fprintf(w, " %s | %s |", hits,
+				delta(lp.OwnTime().InMilliseconds(), b.OwnTime().InMilliseconds(), found))
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w)
+}
+
+/* Functions and lines rated "s_bad", then "s_high" */
+func (s *summary) writeWorst(w io.Writer, n int) {
+	if n <= 0 {
+		return
+	}
+	ownTimeStat, _ := report.GetMADStats(s.functions)
+	items := []string{}
+	for _, severity := range []string{"s_bad", "s_high"} {
+		for _, f := range s.functions {
+			ms := f.OwnTime.InMilliseconds()
+			if report.GetSeverityClass(ms, ownTimeStat) == severity {
+				items = append(items, fmt.Sprintf("- **%s** function %s: %sms self (%s) at %s",
+					severityNames[severity], cell(f.FullName()), f.OwnTime.InMillisecondsStr(),
+					share(ms, s.p.Duration.InMilliseconds()), location(f.Filename, int(f.StartLine))))
+			}
+		}
+		for _, l := range s.lines {
+			if l.Severity == severity {
+				items = append(items, fmt.Sprintf("- **%s** line %s: %sms on line, %d hits",
+					severityNames[severity], location(l.File, l.Line),
+					l.Profile.OwnTime().InMillisecondsStr(), l.Profile.Hits))
+			}
+		}
+	}
+	if len(items) == 0 {
+		return
+	}
+	if len(items) > n {
+		items = items[:n]
+	}
+	fmt.Fprintf(w, "#### Worst %d items\n\n", len(items))
+	fmt.Fprintln(w, strings.Join(items, "\n"))
+	fmt.Fprintln(w)
+}
+
+func (r *MarkdownReporter) render(s *summary, nFunctions, nLines, nWorst int) *bytes.Buffer {
+	var b bytes.Buffer
+	s.writeHeader(&b, r.Base)
+	s.writeFunctions(&b, nFunctions)
+	s.writeLines(&b, nLines)
+	s.writeWorst(&b, nWorst)
+	return &b
+}
+
+func (r *MarkdownReporter) ReportFunctions(p *json.Profile) {
+	s := newSummary(p, r.Base)
+	nFunctions, nLines, nWorst := r.Functions, r.Lines, r.Worst
+	b := r.render(s, nFunctions, nLines, nWorst)
+	truncatedNote := fmt.Sprintf("_Rows were left out to stay within %d bytes._\n", r.MaxLength)
+	truncated := false
+	for r.MaxLength > 0 && b.Len()+len(truncatedNote) > r.MaxLength && nFunctions+nLines+nWorst > 0 {
+		nFunctions, nLines, nWorst = nFunctions/2, nLines/2, nWorst/2
+		b = r.render(s, nFunctions, nLines, nWorst)
+		truncated = true
+	}
+	if r.MaxLength > 0 && b.Len()+len(truncatedNote) > r.MaxLength {
+		/* Not even the header fits, keep the whole lines that do */
+		log.Printf("The markdown summary does not fit in %d bytes, it is cut short\n", r.MaxLength)
+		truncatedNote = fmt.Sprintf("_The summary was cut short to stay within %d bytes._\n", r.MaxLength)
+		keep := r.MaxLength - len(truncatedNote)
+		if keep < 0 {
+			keep = 0
+		}
+		b.Truncate(bytes.LastIndexByte(b.Bytes()[:keep], '\n') + 1)
+		truncated = true
+	}
+	if truncated {
+		b.WriteString(truncatedNote)
+	}
+	if r.MaxLength > 0 && b.Len() > r.MaxLength {
+		b.Truncate(r.MaxLength)
+	}
+	r.w.Write(b.Bytes())
+}
@@ -0,0 +1,95 @@
+package markdown
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+import "fprof/json"
+import "fprof/log"
+
+func profile(selfNsec, inclNsec string) *json.Profile {
+	return json.DecodeFromBytes([]byte(`{
+	"duration": { "sec": 0, "nsec": 10000000 },
+	"files": {
+		"/a.fe": [
+			{
+				"functions": [
+					{
+						"name": "f|g",
+						"start_line": 1,
+						"exclusive_duration": { "sec": 0, "nsec": ` + selfNsec + ` },
+						"inclusive_duration": { "sec": 0, "nsec": ` + inclNsec + ` },
+						"hits": 2
+					}
+				],
+				"hits": 1,
+				"total_duration": { "sec": 0, "nsec": 1000000 }
+			}
+		]
+	}
+}`))
+}
+
+func TestSummary(t *testing.T) {
+	var b bytes.Buffer
+	New(&b).ReportFunctions(profile("1000000", "5000000"))
+	out := b.String()
+	expected := []string{
+		"| Start | Stop | Duration (ms) |",
+		"| 2 | 4.000 | 5.000 | 40.0% | 80.0 | f\\|g | /a.fe:1 |",
+		"| /a.fe:1 | 1 | 1.000 | 0 | 0.000 |",
+	}
+	for _, e := range expected {
+		if !strings.Contains(out, e) {
+			t.Errorf("Expected %q in:\n%s", e, out)
+		}
+	}
+	if strings.Contains(out, "Δ") {
+		t.Errorf("No delta columns expected without a base profile:\n%s", out)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	var b bytes.Buffer
+	r := New(&b)
+	r.Base = profile("1000000", "3000000")
+	r.ReportFunctions(profile("1000000", "5000000"))
+	if !strings.Contains(b.String(), "| +0 | +2.000 | +2.000 |") {
+		t.Errorf("Expected delta columns in:\n%s", b.String())
+	}
+}
+
+func TestMaxLength(t *testing.T) {
+	var b bytes.Buffer
+	r := New(&b)
+	r.MaxLength = 400
+	r.ReportFunctions(profile("1000000", "5000000"))
+	if b.Len() > r.MaxLength {
+		t.Errorf("Summary is %d bytes, more than %d", b.Len(), r.MaxLength)
+	}
+	if !strings.Contains(b.String(), "Rows were left out") {
+		t.Errorf("Expected truncation note in:\n%s", b.String())
+	}
+}
+
+func TestMaxLengthBelowHeader(t *testing.T) {
+	log.Init(io.Discard, "")
+	for _, max := range []int{120, 10} {
+		var b bytes.Buffer
+		r := New(&b)
+		r.MaxLength = max
+		r.ReportFunctions(profile("1000000", "5000000"))
+		if b.Len() > max {
+			t.Errorf("Summary is %d bytes, more than %d:\n%s", b.Len(), max, b.String())
+		}
+	}
+}
+
+func TestCellEscaping(t *testing.T) {
+	if got, want := cell("a&lt;<b"), `a&amp;lt;&lt;b`; got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,52 @@
+package report
+
+import (
+	"strconv"
+)
+
+import "fprof/json"
+import "fprof/stats"
+
+/* The value of a custom metric as shown in tables, empty when not recorded */
+func MetricValueOrNone(metrics json.Metrics, name string) string {
+	if !metrics.Has(name) {
+		return ""
+	}
+	return strconv.FormatFloat(metrics[name], 'f', -1, 64)
+}
+
+func metricStats(declarations []json.MetricDeclaration, values func(add func(json.Metrics))) []*stats.Stats {
+	all := make([]*stats.Stats, len(declarations))
+	for i, m := range declarations {
+		positive := []float64{}
+		values(func(metrics json.Metrics) {
+			if v := metrics[m.Name]; v > 0 {
+				positive = append(positive, v)
+			}
+		})
+		all[i] = stats.MadMedian(positive)
+	}
+	return all
+}
+
+/* Like GetMADStats, for each declared metric of the functions */
+func GetFunctionMetricStats(functions json.FunctionProfileSlice, declarations []json.MetricDeclaration) []*stats.Stats {
+	return metricStats(declarations, func(add func(json.Metrics)) {
+		for _, f := range functions {
+			if f != nil {
+				add(f.Metrics)
+			}
+		}
+	})
+}
+
+/* Like GetLineMADStats, for each declared metric of the lines */
+func GetLineMetricStats(lineProfiles []*json.LineProfile, declarations []json.MetricDeclaration) []*stats.Stats {
+	return metricStats(declarations, func(add func(json.Metrics)) {
+		for _, lp := range lineProfiles {
+			if lp != nil {
+				add(lp.Metrics)
+			}
+		}
+	})
+}
@@ -0,0 +1,125 @@
+package openmetrics
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+import "fprof/json"
+
+/*
+ * OpenMetricsReporter writes the top functions of a profile as OpenMetrics
+ * text gauges, e.g. for node_exporter's textfile collector. Only the Top
+ * functions by self time are written to keep the label cardinality bounded.
+ */
+type OpenMetricsReporter struct {
+	w   io.Writer
+	Top int
+}
+
+func New(w io.Writer) *OpenMetricsReporter {
+	return &OpenMetricsReporter{w, 50}
+}
+
+func escapeLabel(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return strings.Replace(s, "\n", `\n`, -1)
+}
+
+func float(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type sample struct {
+	labels    string
+	self      json.TimeSpec
+	inclusive json.TimeSpec
+	calls     json.Counter
+	metrics   json.Metrics
+}
+
+/* Metric names may only have letters, digits, underscores and colons */
+func metricName(s string) string {
+	return strings.Map(func(ch rune) rune {
+		if ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '_' || ch == ':' {
+			return ch
+		}
+		return '_'
+	}, s)
+}
+
+/* The family of a custom metric, the unit being a suffix of its name */
+func customFamily(m *json.MetricDeclaration) (string, string) {
+	name := "fprof_function_" + metricName(m.Name)
+	unit := metricName(m.Unit)
+	if unit != "" && !strings.HasSuffix(name, "_"+unit) {
+		name += "_" + unit
+	}
+	return name, unit
+}
+
+func (r *OpenMetricsReporter) family(name, unit, help string) {
+	fmt.Fprintf(r.w, "# TYPE %s gauge\n", name)
+	if unit != "" {
+		fmt.Fprintf(r.w, "# UNIT %s %s\n", name, unit)
+	}
+	fmt.Fprintf(r.w, "# HELP %s %s\n", name, help)
+}
+
+func (r *OpenMetricsReporter) ReportFunctions(p *json.Profile) {
+	/* Functions sharing a label set are summed, a label set must be unique */
+	samples := []*sample{}
+	byLabels := make(map[string]*sample)
+	for _, f := range p.FileProfileMap.GetFunctionsSortedByExlusiveTime() {
+		if f == nil {
+			continue
+		}
+		labels := fmt.Sprintf(`namespace="%s",function="%s",file="%s"`,
+			escapeLabel(f.NameSpace), escapeLabel(f.Name), escapeLabel(f.Filename))
+		s := byLabels[labels]
+		if s == nil {
+			if r.Top > 0 && len(samples) == r.Top {
+				continue
+			}
+			s = &sample{labels: labels}
+			byLabels[labels] = s
+			samples = append(samples, s)
+		}
+		s.self.Add(f.OwnTime)
+		s.inclusive.Add(f.InclusiveDuration)
+		s.calls += f.Hits
+		s.metrics.Add(f.Metrics, p.Metrics)
+	}
+
+	r.family("fprof_profile_duration_seconds", "seconds", "Wall time covered by the profile.")
+	fmt.Fprintf(r.w, "fprof_profile_duration_seconds %s\n", float(p.Duration.InSeconds()))
+	r.family("fprof_profile_start_timestamp_seconds", "seconds", "Time the profile was started.")
+	fmt.Fprintf(r.w, "fprof_profile_start_timestamp_seconds %s\n", float(p.Start.InSeconds()))
+
+	r.family("fprof_function_self_seconds", "seconds", "Time spent in the function itself.")
+	for _, s := range samples {
+		fmt.Fprintf(r.w, "fprof_function_self_seconds{%s} %s\n", s.labels, float(s.self.InSeconds()))
+	}
+	r.family("fprof_function_inclusive_seconds", "seconds", "Time spent in the function including the functions it called.")
+	for _, s := range samples {
+		fmt.Fprintf(r.w, "fprof_function_inclusive_seconds{%s} %s\n", s.labels, float(s.inclusive.InSeconds()))
+	}
+	r.family("fprof_function_calls", "", "Number of calls to the function.")
+	for _, s := range samples {
+		fmt.Fprintf(r.w, "fprof_function_calls{%s} %d\n", s.labels, s.calls)
+	}
+	for i := range p.Metrics {
+		m := &p.Metrics[i]
+		name, unit := customFamily(m)
+		r.family(name, unit, "Custom metric "+escapeLabel(m.Name)+" of the function.")
+		for _, s := range samples {
+			if s.metrics.Has(m.Name) {
+				fmt.Fprintf(r.w, "%s{%s} %s\n", name, s.labels, float(s.metrics[m.Name]))
+			}
+		}
+	}
+	fmt.Fprintln(r.w, "# EOF")
+}
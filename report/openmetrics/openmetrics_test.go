@@ -0,0 +1,66 @@
+package openmetrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+import "fprof/json"
+
+var profileJson = []byte(`{
+	"start": { "sec": 1400000000, "nsec": 500000000 },
+	"duration": { "sec": 1, "nsec": 0 },
+	"files": {
+		"/a.fe": [
+			{
+				"functions": [
+					{
+						"name": "say\"hi\"",
+						"namespace": "Obj",
+						"start_line": 1,
+						"exclusive_duration": { "sec": 0, "nsec": 0 },
+						"inclusive_duration": { "sec": 0, "nsec": 250000000 },
+						"hits": 4
+					},
+					{
+						"name": "small",
+						"start_line": 1,
+						"exclusive_duration": { "sec": 0, "nsec": 0 },
+						"inclusive_duration": { "sec": 0, "nsec": 1000 },
+						"hits": 1
+					}
+				],
+				"hits": 1,
+				"total_duration": { "sec": 0, "nsec": 1000 }
+			}
+		]
+	}
+}`)
+
+func TestReportFunctions(t *testing.T) {
+	var b bytes.Buffer
+	r := New(&b)
+	r.Top = 1
+	r.ReportFunctions(json.DecodeFromBytes(profileJson))
+	out := b.String()
+
+	expected := []string{
+		"fprof_profile_duration_seconds 1\n",
+		"fprof_profile_start_timestamp_seconds 1.4000000005e+09\n",
+		"# TYPE fprof_function_self_seconds gauge\n# UNIT fprof_function_self_seconds seconds\n",
+		`fprof_function_self_seconds{namespace="Obj",function="say\"hi\"",file="/a.fe"} 0.25` + "\n",
+		`fprof_function_calls{namespace="Obj",function="say\"hi\"",file="/a.fe"} 4` + "\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(out, e) {
+			t.Errorf("Expected %q in:\n%s", e, out)
+		}
+	}
+	if strings.Contains(out, "small") {
+		t.Errorf("Only the top function is expected:\n%s", out)
+	}
+	if !strings.HasSuffix(out, "# EOF\n") {
+		t.Errorf("Output must end with # EOF")
+	}
+}
@@ -3,6 +3,7 @@ package report
 import (
 	"fprof/log"
 	"io"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -41,3 +42,12 @@ func TimingsAndFilenameLineInfo(record string) (LineMetric, string) {
 
 	return LineMetric(record[0:firstSlash]), record[firstSlash:]
 }
+
+func SortedFilenames(fileProfiles json.FileProfile) []string {
+	files := make([]string, 0, len(fileProfiles))
+	for file := range fileProfiles {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files
+}
@@ -0,0 +1,243 @@
+package sarif
+
+import (
+	stdjson "encoding/json"
+	"fmt"
+	"fprof/log"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+import "fprof/json"
+import "fprof/report"
+
+const (
+	Version = "2.1.0"
+	Schema  = "https://json.schemastore.org/sarif-2.1.0.json"
+
+	hotLineRule     = "fprof/hot-line"
+	hotFunctionRule = "fprof/hot-function"
+)
+
+/* SARIF levels of the reported severity classes */
+var severityLevels = map[string]string{
+	"s_bad":  "warning",
+	"s_high": "note",
+}
+
+type message struct {
+	Text string `json:"text"`
+}
+
+type artifactLocation struct {
+	Uri       string `json:"uri"`
+	UriBaseId string `json:"uriBaseId,omitempty"`
+}
+
+type region struct {
+	StartLine int `json:"startLine"`
+}
+
+type physicalLocation struct {
+	ArtifactLocation artifactLocation `json:"artifactLocation"`
+	Region           region           `json:"region"`
+}
+
+type location struct {
+	PhysicalLocation physicalLocation `json:"physicalLocation"`
+}
+
+type result struct {
+	RuleId     string                 `json:"ruleId"`
+	Level      string                 `json:"level"`
+	Message    message                `json:"message"`
+	Locations  []location             `json:"locations"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type rule struct {
+	Id               string  `json:"id"`
+	Name             string  `json:"name"`
+	ShortDescription message `json:"shortDescription"`
+}
+
+type driver struct {
+	Name           string `json:"name"`
+	InformationUri string `json:"informationUri"`
+	Rules          []rule `json:"rules"`
+}
+
+type tool struct {
+	Driver driver `json:"driver"`
+}
+
+type sarifRun struct {
+	Tool               tool                        `json:"tool"`
+	OriginalUriBaseIds map[string]artifactLocation `json:"originalUriBaseIds,omitempty"`
+	Results            []result                    `json:"results"`
+}
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+/*
+ * SarifReporter writes the lines and functions rated "s_high" or "s_bad"
+ * as SARIF results, so code hosts can show them as annotations. Files below
+ * SrcRoot, and relative ones, are given relative to the SRCROOT base.
+ */
+type SarifReporter struct {
+	w       io.Writer
+	SrcRoot string
+}
+
+func New(w io.Writer) *SarifReporter {
+	return &SarifReporter{w, ""}
+}
+
+const srcRootId = "SRCROOT"
+
+/* The file URI of an absolute path, escaped as URIs need */
+func fileUri(file string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(file)}).String()
+}
+
+/* The relative URI of a relative path */
+func relativeUri(file string) string {
+	return (&url.URL{Path: filepath.ToSlash(file)}).String()
+}
+
+/* SrcRoot made absolute, for the SRCROOT base to be a file URI */
+func (r *SarifReporter) srcRoot() string {
+	if abs, err := filepath.Abs(r.SrcRoot); err == nil {
+		return abs
+	}
+	return r.SrcRoot
+}
+
+func (r *SarifReporter) location(file string, line int) []location {
+	var a artifactLocation
+	switch {
+	case !filepath.IsAbs(file):
+		a = artifactLocation{relativeUri(file), srcRootId}
+	default:
+		a = artifactLocation{Uri: fileUri(file)}
+		if r.SrcRoot != "" {
+			if rel, err := filepath.Rel(r.srcRoot(), file); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				a = artifactLocation{relativeUri(rel), srcRootId}
+			}
+		}
+	}
+	if line < 1 {
+		line = 1
+	}
+	return []location{{physicalLocation{a, region{line}}}}
+}
+
+/* The custom metrics recorded of a function or line, by name, nil if none */
+func metricProperties(metrics json.Metrics, declarations []json.MetricDeclaration) map[string]float64 {
+	var properties map[string]float64
+	for _, m := range declarations {
+		if !metrics.Has(m.Name) {
+			continue
+		}
+		if properties == nil {
+			properties = make(map[string]float64)
+		}
+		properties[m.Name] = metrics[m.Name]
+	}
+	return properties
+}
+
+func withMetrics(properties map[string]interface{}, metrics map[string]float64) map[string]interface{} {
+	if metrics != nil {
+		properties["metrics"] = metrics
+	}
+	return properties
+}
+
+func share(ms, totalMs float64) float64 {
+	if totalMs <= 0 {
+		return 0
+	}
+	return ms * 100 / totalMs
+}
+
+func (r *SarifReporter) results(p *json.Profile) []result {
+	totalMs := p.Duration.InMilliseconds()
+	results := []result{}
+
+	functions := p.FileProfileMap.GetFunctionsSortedByExlusiveTime()
+	ownTimeStat, _ := report.GetMADStats(functions)
+	for _, f := range functions {
+		if f == nil || f.Filename == "" {
+			continue
+		}
+		ms := f.OwnTime.InMilliseconds()
+		level := severityLevels[report.GetSeverityClass(ms, ownTimeStat)]
+		if level == "" || ms <= 0 {
+			continue
+		}
+		results = append(results, result{
+			RuleId: hotFunctionRule,
+			Level:  level,
+			Message: message{fmt.Sprintf("%s() spent %sms in itself (%.1f%% of %sms), %d calls, %sms inclusive",
+				f.FullName(), f.OwnTime.InMillisecondsStr(), share(ms, totalMs), p.Duration.InMillisecondsStr(),
+				f.Hits, f.InclusiveDuration.InMillisecondsStr())},
+			Locations: r.location(f.Filename, int(f.StartLine)),
+			Properties: withMetrics(map[string]interface{}{
+				"selfMs": ms, "inclusiveMs": f.InclusiveDuration.InMilliseconds(), "calls": f.Hits,
+			}, metricProperties(f.Metrics, p.Metrics)),
+		})
+	}
+
+	for _, l := range report.GetLines(p.FileProfileMap) {
+		ms := l.Profile.OwnTime().InMilliseconds()
+		level := severityLevels[l.Severity]
+		if level == "" || ms <= 0 {
+			continue
+		}
+		results = append(results, result{
+			RuleId: hotLineRule,
+			Level:  level,
+			Message: message{fmt.Sprintf("%sms on this line (%.1f%% of %sms), %d hits",
+				l.Profile.OwnTime().InMillisecondsStr(), share(ms, totalMs), p.Duration.InMillisecondsStr(),
+				l.Profile.Hits)},
+			Locations: r.location(l.File, l.Line),
+			Properties: withMetrics(map[string]interface{}{
+				"timeOnLineMs": ms, "hits": l.Profile.Hits,
+			}, metricProperties(l.Profile.Metrics, p.Metrics)),
+		})
+	}
+	return results
+}
+
+func (r *SarifReporter) ReportFunctions(p *json.Profile) {
+	run := sarifRun{
+		Tool: tool{driver{
+			Name:           "fprof",
+			InformationUri: "https://github.com/cention-nazri/fprof",
+			Rules: []rule{
+				{hotFunctionRule, "HotFunction", message{"Function with a high self time compared to the other functions"}},
+				{hotLineRule, "HotLine", message{"Line with a high time on line compared to the rest of its file"}},
+			},
+		}},
+		Results: r.results(p),
+	}
+	if r.SrcRoot != "" {
+		/* The base must end in a slash for the relative URIs to resolve below it */
+		run.OriginalUriBaseIds = map[string]artifactLocation{
+			srcRootId: {Uri: strings.TrimSuffix(fileUri(r.srcRoot()), "/") + "/"},
+		}
+	}
+
+	encoder := stdjson.NewEncoder(r.w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(sarifLog{Version, Schema, []sarifRun{run}}); err != nil {
+		log.Fatal(err)
+	}
+}
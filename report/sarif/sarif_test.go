@@ -0,0 +1,107 @@
+package sarif
+
+import (
+	"bytes"
+	stdjson "encoding/json"
+	"strings"
+	"testing"
+)
+
+import "fprof/json"
+
+/* One line far slower than the others of the file */
+var profileJson = []byte(`{
+	"duration": { "sec": 0, "nsec": 100000000 },
+	"files": {
+		"/src/app/a.fe": [
+			{ "hits": 1, "total_duration": { "sec": 0, "nsec": 1000000 } },
+			{ "hits": 1, "total_duration": { "sec": 0, "nsec": 1100000 } },
+			{ "hits": 1, "total_duration": { "sec": 0, "nsec": 1200000 } },
+			{ "hits": 40, "total_duration": { "sec": 0, "nsec": 50000000 } }
+		]
+	}
+}`)
+
+func TestReportFunctions(t *testing.T) {
+	var b bytes.Buffer
+	r := New(&b)
+	r.SrcRoot = "/src/app"
+	r.ReportFunctions(json.DecodeFromBytes(profileJson))
+
+	var out sarifLog
+	if err := stdjson.Unmarshal(b.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Version != "2.1.0" || len(out.Runs) != 1 {
+		t.Fatalf("Unexpected log %+v", out)
+	}
+	results := out.Runs[0].Results
+	if len(results) != 1 {
+		t.Fatalf("Expected only the slow line to be reported, got %+v", results)
+	}
+	res := results[0]
+	if res.RuleId != hotLineRule || res.Level != "warning" {
+		t.Errorf("Unexpected rule %s or level %s", res.RuleId, res.Level)
+	}
+	loc := res.Locations[0].PhysicalLocation
+	if loc.ArtifactLocation != (artifactLocation{"a.fe", "SRCROOT"}) || loc.Region.StartLine != 4 {
+		t.Errorf("Unexpected location %+v", loc)
+	}
+	if !strings.HasPrefix(res.Message.Text, "50.000ms on this line (50.0% of 100.000ms), 40 hits") {
+		t.Errorf("Unexpected message %q", res.Message.Text)
+	}
+}
+
+func TestMetricProperties(t *testing.T) {
+	withMetrics := strings.Replace(strings.Replace(string(profileJson),
+		`"files"`, `"metrics": [ { "name": "allocated", "unit": "bytes" } ], "files"`, 1),
+		`"hits": 40,`, `"hits": 40, "metrics": { "allocated": 4096 },`, 1)
+	var b bytes.Buffer
+	New(&b).ReportFunctions(json.DecodeFromBytes([]byte(withMetrics)))
+
+	var out sarifLog
+	if err := stdjson.Unmarshal(b.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	results := out.Runs[0].Results
+	if len(results) != 1 {
+		t.Fatalf("Expected only the slow line to be reported, got %+v", results)
+	}
+	metrics, _ := results[0].Properties["metrics"].(map[string]interface{})
+	if metrics["allocated"] != float64(4096) {
+		t.Errorf("Expected 4096 allocated in the properties, got %v", results[0].Properties)
+	}
+}
+
+func TestLocationUris(t *testing.T) {
+	r := New(nil)
+	r.SrcRoot = "/src/my app"
+	tests := []struct {
+		file     string
+		expected artifactLocation
+	}{
+		{"/src/my app/lib/a #1.fe", artifactLocation{"lib/a%20%231.fe", "SRCROOT"}},
+		{"/other/b%.fe", artifactLocation{Uri: "file:///other/b%25.fe"}},
+		{"/src/my app/../x.fe", artifactLocation{Uri: "file:///src/my%20app/../x.fe"}},
+		{"rel/c d.fe", artifactLocation{"rel/c%20d.fe", "SRCROOT"}},
+	}
+	for _, tt := range tests {
+		if got := r.location(tt.file, 1)[0].PhysicalLocation.ArtifactLocation; got != tt.expected {
+			t.Errorf("%s: expected %+v, got %+v", tt.file, tt.expected, got)
+		}
+	}
+}
+
+func TestSrcRootBase(t *testing.T) {
+	var b bytes.Buffer
+	r := New(&b)
+	r.SrcRoot = "/src/my app/"
+	r.ReportFunctions(json.DecodeFromBytes(profileJson))
+	var out sarifLog
+	if err := stdjson.Unmarshal(b.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if base := out.Runs[0].OriginalUriBaseIds["SRCROOT"].Uri; base != "file:///src/my%20app/" {
+		t.Errorf("Unexpected SRCROOT base %s", base)
+	}
+}
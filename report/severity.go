@@ -0,0 +1,82 @@
+package report
+
+import (
+	"fprof/json"
+	"fprof/stats"
+)
+
+var SEVERITY_LOW = .5
+var SEVERITY_MEDIUM = 1.0
+var SEVERITY_HIGH = 2.0
+
+/* Severity classes ordered from the least to the most severe */
+var SeverityClasses = []string{"s_low", "s_medium", "s_high", "s_bad"}
+
+/* Background colours of the severity classes, as used by the html report */
+var SeverityColors = map[string]string{
+	"s_low":    "limegreen",
+	"s_medium": "darkorange",
+	"s_high":   "lightsalmon",
+	"s_bad":    "salmon",
+}
+
+func GetSeverityClass(v float64, stat *stats.Stats) string {
+	if stat.MAD == 0 {
+		return "s_low"
+	}
+	d := v - stat.Median
+	severity := d / stat.MAD
+	if severity < SEVERITY_LOW {
+		return "s_low"
+	}
+	if severity < SEVERITY_MEDIUM {
+		return "s_medium"
+	}
+	if severity < SEVERITY_HIGH {
+		return "s_high"
+	}
+	return "s_bad"
+}
+
+func GetMADStats(functionCalls json.FunctionProfileSlice) (*stats.Stats, *stats.Stats) {
+	ownTimes := make([]float64, 0, len(functionCalls))
+	incTimes := make([]float64, 0, len(functionCalls))
+	for _, fc := range functionCalls {
+		if fc == nil {
+			continue
+		}
+		d := fc.OwnTime.InMilliseconds()
+		if d > 0 {
+			ownTimes = append(ownTimes, d)
+		}
+		d = fc.InclusiveDuration.InMilliseconds()
+		if d > 0 {
+			incTimes = append(incTimes, d)
+		}
+	}
+	ownTimeStat := stats.MadMedian(ownTimes)
+	incTimeStat := stats.MadMedian(incTimes)
+
+	return ownTimeStat, incTimeStat
+}
+
+/* Same as GetMADStats for the time on and the time in functions of lines */
+func GetLineMADStats(lineProfiles []*json.LineProfile) (*stats.Stats, *stats.Stats) {
+	timesOnLine := make([]float64, 0, len(lineProfiles))
+	timesInFunction := make([]float64, 0, len(lineProfiles))
+	for _, lp := range lineProfiles {
+		if lp == nil {
+			continue
+		}
+		ownTime := lp.OwnTime()
+		d := ownTime.InMilliseconds()
+		if d > 0 {
+			timesOnLine = append(timesOnLine, d)
+		}
+		d = lp.TimeInFunctions.InMilliseconds()
+		if d > 0 {
+			timesInFunction = append(timesInFunction, d)
+		}
+	}
+	return stats.MadMedian(timesOnLine), stats.MadMedian(timesInFunction)
+}
@@ -0,0 +1,238 @@
+/*
+Package summary exports the numbers fprof derives from a ferite profile as
+JSON, for dashboards and other tools.
+
+The document follows the schema below. Times are integer nanoseconds, lines
+are 1-based. Fields are only ever added within a schema version; anything
+else bumps Version.
+
+	{
+	  "schema": "fprof-summary",
+	  "version": 1,
+	  "start_ns", "stop_ns", "duration_ns": profile timestamps and duration,
+	  "metrics": [{ "name", "unit", "aggregation" }]: custom metrics, if any,
+	  "functions": [{
+	    "id": index in this array,
+	    "name", "namespace", "full_name", "file", "start_line", "native",
+	    "calls": number of calls,
+	    "self_ns": time spent in the function itself (OwnTime),
+	    "inclusive_ns", "exclusive_ns": as recorded by ferite,
+	    "calling_places", "calling_files": distinct call sites and files,
+	    "unknown_callers_ns": time not accounted for by the known callers,
+	    "callers": [{ "function", "file", "line", "calls", "time_ns" }],
+	    "metrics": { name: value } of the custom metrics recorded
+	  }],
+	  "files": [{
+	    "file", "lines": profiled lines,
+	    "hits", "self_ns", "calls_made", "time_in_functions_ns": line totals,
+	    "metrics": line totals of the custom metrics, by their aggregation
+	  }],
+	  "lines": [{
+	    "file", "line", "hits",
+	    "total_ns": time on the line including the functions it called,
+	    "self_ns": time on the line itself,
+	    "calls_made", "time_in_functions_ns",
+	    "calls": [{ "function_id", "calls", "time_ns" }]: resolved call sites,
+	    "metrics": { name: value } of the custom metrics recorded
+	  }],
+	  "edges": [{ "caller", "callee", "calls", "time_ns" }]: call graph by full name
+	}
+*/
+package summary
+
+import (
+	stdjson "encoding/json"
+	"fprof/log"
+	"io"
+	"sort"
+)
+
+import "fprof/json"
+import "fprof/report"
+
+const Schema = "fprof-summary"
+const Version = 1
+
+type Caller struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Calls    uint64 `json:"calls"`
+	TimeNs   int64  `json:"time_ns"`
+}
+
+type Function struct {
+	Id               int          `json:"id"`
+	Name             string       `json:"name"`
+	NameSpace        string       `json:"namespace"`
+	FullName         string       `json:"full_name"`
+	File             string       `json:"file"`
+	StartLine        int          `json:"start_line"`
+	Native           bool         `json:"native"`
+	Calls            uint64       `json:"calls"`
+	SelfNs           int64        `json:"self_ns"`
+	InclusiveNs      int64        `json:"inclusive_ns"`
+	ExclusiveNs      int64        `json:"exclusive_ns"`
+	CallingPlaces    int          `json:"calling_places"`
+	CallingFiles     int          `json:"calling_files"`
+	UnknownCallersNs int64        `json:"unknown_callers_ns"`
+	Callers          []Caller     `json:"callers"`
+	Metrics          json.Metrics `json:"metrics,omitempty"`
+}
+
+type File struct {
+	File              string       `json:"file"`
+	Lines             int          `json:"lines"`
+	Hits              uint64       `json:"hits"`
+	SelfNs            int64        `json:"self_ns"`
+	CallsMade         uint64       `json:"calls_made"`
+	TimeInFunctionsNs int64        `json:"time_in_functions_ns"`
+	Metrics           json.Metrics `json:"metrics,omitempty"`
+}
+
+type Call struct {
+	FunctionId int    `json:"function_id"`
+	Calls      uint64 `json:"calls"`
+	TimeNs     int64  `json:"time_ns"`
+}
+
+type Line struct {
+	File              string       `json:"file"`
+	Line              int          `json:"line"`
+	Hits              uint64       `json:"hits"`
+	TotalNs           int64        `json:"total_ns"`
+	SelfNs            int64        `json:"self_ns"`
+	CallsMade         uint64       `json:"calls_made"`
+	TimeInFunctionsNs int64        `json:"time_in_functions_ns"`
+	Calls             []Call       `json:"calls"`
+	Metrics           json.Metrics `json:"metrics,omitempty"`
+}
+
+type Edge struct {
+	Caller string `json:"caller"`
+	Callee string `json:"callee"`
+	Calls  uint64 `json:"calls"`
+	TimeNs int64  `json:"time_ns"`
+}
+
+type Summary struct {
+	Schema     string                   `json:"schema"`
+	Version    int                      `json:"version"`
+	StartNs    int64                    `json:"start_ns"`
+	StopNs     int64                    `json:"stop_ns"`
+	DurationNs int64                    `json:"duration_ns"`
+	Metrics    []json.MetricDeclaration `json:"metrics,omitempty"`
+	Functions  []Function               `json:"functions"`
+	Files      []File                   `json:"files"`
+	Lines      []Line                   `json:"lines"`
+	Edges      []Edge                   `json:"edges"`
+}
+
+type SummaryReporter struct {
+	w io.Writer
+}
+
+func New(w io.Writer) *SummaryReporter {
+	return &SummaryReporter{w}
+}
+
+func newFunction(id int, f *json.FunctionProfile) Function {
+	callers := make([]Caller, 0, len(f.Callers))
+	for _, c := range f.Callers {
+		if c == nil {
+			continue
+		}
+		callers = append(callers, Caller{
+			c.FullName(), c.Filename, int(c.At), uint64(c.Frequency), c.TotalDuration.InNanoseconds(),
+		})
+	}
+	return Function{
+		Id:               id,
+		Name:             f.Name,
+		NameSpace:        f.NameSpace,
+		FullName:         f.FullName(),
+		File:             f.Filename,
+		StartLine:        int(f.StartLine),
+		Native:           f.IsNative,
+		Calls:            uint64(f.Hits),
+		SelfNs:           f.OwnTime.InNanoseconds(),
+		InclusiveNs:      f.InclusiveDuration.InNanoseconds(),
+		ExclusiveNs:      f.ExclusiveDuration.InNanoseconds(),
+		CallingPlaces:    f.CountCallingPlaces(),
+		CallingFiles:     f.CountCallingFiles(),
+		UnknownCallersNs: f.GetTimeSpentByUnknownCallers().InNanoseconds(),
+		Callers:          callers,
+		Metrics:          f.Metrics,
+	}
+}
+
+func NewSummary(p *json.Profile) *Summary {
+	s := &Summary{
+		Schema:     Schema,
+		Version:    Version,
+		StartNs:    p.Start.InNanoseconds(),
+		StopNs:     p.Stop.InNanoseconds(),
+		DurationNs: p.Duration.InNanoseconds(),
+		Metrics:    p.Metrics,
+		Functions:  []Function{},
+		Files:      []File{},
+		Lines:      []Line{},
+		Edges:      []Edge{},
+	}
+
+	functions := p.FileProfileMap.GetFunctionsSortedByExlusiveTime()
+	ids := make(map[*json.FunctionProfile]int)
+	for _, f := range functions {
+		if f == nil {
+			continue
+		}
+		ids[f] = len(s.Functions)
+		s.Functions = append(s.Functions, newFunction(ids[f], f))
+	}
+
+	for _, file := range report.SortedFilenames(p.FileProfileMap) {
+		total := File{File: file}
+		for i, lp := range p.FileProfileMap[file] {
+			if lp == nil {
+				continue
+			}
+			line := Line{
+				File:              file,
+				Line:              i + 1,
+				Hits:              uint64(lp.Hits),
+				TotalNs:           lp.TotalDuration.InNanoseconds(),
+				SelfNs:            lp.OwnTime().InNanoseconds(),
+				CallsMade:         uint64(lp.CallsMade),
+				TimeInFunctionsNs: lp.TimeInFunctions.InNanoseconds(),
+				Calls:             []Call{},
+				Metrics:           lp.Metrics,
+			}
+			sort.Stable(lp.FunctionCalls)
+			for _, c := range lp.FunctionCalls {
+				line.Calls = append(line.Calls, Call{ids[c.To], uint64(c.CallsMade), c.TimeInFunctions.InNanoseconds()})
+			}
+			s.Lines = append(s.Lines, line)
+
+			total.Lines++
+			total.Hits += line.Hits
+			total.SelfNs += line.SelfNs
+			total.CallsMade += line.CallsMade
+			total.TimeInFunctionsNs += line.TimeInFunctionsNs
+			total.Metrics.Add(lp.Metrics, p.Metrics)
+		}
+		s.Files = append(s.Files, total)
+	}
+
+	for _, e := range report.NewCallGraph(functions).Edges {
+		s.Edges = append(s.Edges, Edge{e.From.Name, e.To.Name, uint64(e.Calls), e.Time.InNanoseconds()})
+	}
+	return s
+}
+
+func (r *SummaryReporter) ReportFunctions(p *json.Profile) {
+	encoder := stdjson.NewEncoder(r.w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(NewSummary(p)); err != nil {
+		log.Fatal(err)
+	}
+}
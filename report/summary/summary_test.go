@@ -0,0 +1,73 @@
+package summary
+
+import (
+	"bytes"
+	stdjson "encoding/json"
+	"testing"
+)
+
+import "fprof/json"
+
+var profileJson = []byte(`{
+	"start": { "sec": 1, "nsec": 5 },
+	"duration": { "sec": 2, "nsec": 0 },
+	"files": {
+		"/a.fe": [
+			{
+				"functions": [
+					{
+						"name": "f",
+						"namespace": "NS",
+						"start_line": 1,
+						"exclusive_duration": { "sec": 0, "nsec": 1000 },
+						"inclusive_duration": { "sec": 1, "nsec": 0 },
+						"hits": 3,
+						"callers": [
+							{ "at": 2, "file": "/a.fe", "frequency": 2, "name": "main",
+							  "total_duration": { "sec": 0, "nsec": 600000000 } }
+						]
+					}
+				],
+				"hits": 3,
+				"total_duration": { "sec": 0, "nsec": 3000 }
+			},
+			{ "hits": 1, "total_duration": { "sec": 0, "nsec": 700000000 } }
+		]
+	}
+}`)
+
+func TestReportFunctions(t *testing.T) {
+	var b bytes.Buffer
+	New(&b).ReportFunctions(json.DecodeFromBytes(profileJson))
+
+	var s Summary
+	if err := stdjson.Unmarshal(b.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Schema != Schema || s.Version != Version {
+		t.Errorf("Unexpected schema %s version %d", s.Schema, s.Version)
+	}
+	if s.StartNs != 1000000005 || s.DurationNs != 2000000000 {
+		t.Errorf("Unexpected times: start %d duration %d", s.StartNs, s.DurationNs)
+	}
+	if len(s.Functions) != 1 {
+		t.Fatalf("Expected 1 function, got %d", len(s.Functions))
+	}
+	f := s.Functions[0]
+	if f.FullName != "NS.f" || f.SelfNs != 999999000 || f.UnknownCallersNs != 400000000 || f.CallingPlaces != 1 {
+		t.Errorf("Unexpected function %+v", f)
+	}
+	if len(s.Lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %d", len(s.Lines))
+	}
+	l := s.Lines[1]
+	if l.Line != 2 || l.SelfNs != 100000000 || l.CallsMade != 2 || len(l.Calls) != 1 || l.Calls[0].FunctionId != 0 {
+		t.Errorf("Unexpected line %+v", l)
+	}
+	if len(s.Files) != 1 || s.Files[0].Lines != 2 || s.Files[0].Hits != 4 {
+		t.Errorf("Unexpected files %+v", s.Files)
+	}
+	if len(s.Edges) != 1 || s.Edges[0] != (Edge{"main", "NS.f", 2, 600000000}) {
+		t.Errorf("Unexpected edges %+v", s.Edges)
+	}
+}
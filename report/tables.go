@@ -0,0 +1,36 @@
+package report
+
+/* Column titles shared by the reports */
+type CodeTableHeader struct {
+	Line            string
+	Hits            string
+	TimeOnLine      string
+	CallsMade       string
+	TimeInFunctions string
+}
+
+var CodeTableHeaders = CodeTableHeader{
+	Line:            "Line",
+	Hits:            "Hits",
+	TimeOnLine:      "Time on line (ms)",
+	CallsMade:       "Calls Made",
+	TimeInFunctions: "Time in functions",
+}
+
+type FunctionTableHeader struct {
+	Calls       string
+	Places      string
+	Files       string
+	SelfMs      string
+	InclusiveMs string
+	Ratio       string
+}
+
+var FunctionTableHeaders = FunctionTableHeader{
+	Calls:       "Calls",
+	Places:      "Places",
+	Files:       "Files",
+	SelfMs:      "Self (ms)",
+	InclusiveMs: "Inclusive (ms)",
+	Ratio:       "Incl/Excl %",
+}
@@ -0,0 +1,199 @@
+package text
+
+import (
+	"fmt"
+	"fprof/log"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+import "fprof/json"
+import "fprof/report"
+import "fprof/stats"
+
+const (
+	SortBySelf      = "self"
+	SortByInclusive = "inclusive"
+	SortByCalls     = "calls"
+	SortByRatio     = "ratio"
+)
+
+var SortKeys = []string{SortBySelf, SortByInclusive, SortByCalls, SortByRatio}
+
+/* ANSI colours for the severity classes */
+var severityColors = map[string]string{
+	"s_low":    "\x1b[32m",
+	"s_medium": "\x1b[33m",
+	"s_high":   "\x1b[31m",
+	"s_bad":    "\x1b[1;31m",
+}
+
+const colorReset = "\x1b[0m"
+
+/*
+ * TextReporter prints the top functions of a profile as an aligned plain
+ * text table, for when a browser is not at hand.
+ */
+type TextReporter struct {
+	w      io.Writer
+	Top    int
+	SortBy string
+	Filter *regexp.Regexp
+	Color  bool
+}
+
+func New(w io.Writer) *TextReporter {
+	return &TextReporter{w, 20, SortBySelf, nil, false}
+}
+
+type functionSorter struct {
+	functions json.FunctionProfileSlice
+	less      func(a, b *json.FunctionProfile) bool
+}
+
+func (s functionSorter) Len() int           { return len(s.functions) }
+func (s functionSorter) Less(i, j int) bool { return s.less(s.functions[i], s.functions[j]) }
+func (s functionSorter) Swap(i, j int)      { s.functions.Swap(i, j) }
+
+func (r *TextReporter) sort(functions json.FunctionProfileSlice) {
+	var less func(a, b *json.FunctionProfile) bool
+	switch r.SortBy {
+	case SortBySelf:
+		/* Already sorted that way */
+		return
+	case SortByInclusive:
+		less = func(a, b *json.FunctionProfile) bool {
+			return b.InclusiveDuration.IsLessThan(&a.InclusiveDuration)
+		}
+	case SortByCalls:
+		less = func(a, b *json.FunctionProfile) bool { return a.Hits > b.Hits }
+	case SortByRatio:
+		less = func(a, b *json.FunctionProfile) bool { return a.OwnTimeRatio() > b.OwnTimeRatio() }
+	default:
+		log.Fatal("Unknown sort key ", r.SortBy, ", expecting one of: ", strings.Join(SortKeys, ", "))
+	}
+	sort.Stable(functionSorter{functions, less})
+}
+
+/* A cell knows its severity class so that it can be coloured after padding */
+type cell struct {
+	text  string
+	class string
+}
+
+func (r *TextReporter) selectFunctions(functions json.FunctionProfileSlice) json.FunctionProfileSlice {
+	selected := json.FunctionProfileSlice{}
+	for _, f := range functions {
+		if f == nil {
+			continue
+		}
+		if r.Filter != nil && !r.Filter.MatchString(f.FullName()) {
+			continue
+		}
+		selected = append(selected, f)
+	}
+	r.sort(selected)
+	if r.Top > 0 && len(selected) > r.Top {
+		selected = selected[:r.Top]
+	}
+	return selected
+}
+
+func functionRow(f *json.FunctionProfile, ownTimeStat, incTimeStat *stats.Stats, metrics []json.MetricDeclaration, metricStats []*stats.Stats) []cell {
+	ratio := ""
+	if f.InclusiveDuration.InMilliseconds() > 0 {
+		ratio = fmt.Sprintf("%3.1f", f.OwnTimeRatio())
+	}
+	location := f.Filename
+	if f.StartLine > 0 {
+		location = fmt.Sprintf("%s:%d", f.Filename, f.StartLine)
+	}
+	row := []cell{
+		{fmt.Sprint(f.Hits), ""},
+		{fmt.Sprint(f.CountCallingPlaces()), ""},
+		{fmt.Sprint(f.CountCallingFiles()), ""},
+		{f.OwnTime.InMillisecondsStr(), report.GetSeverityClass(f.OwnTime.InMilliseconds(), ownTimeStat)},
+		{f.InclusiveDuration.InMillisecondsStr(), report.GetSeverityClass(f.InclusiveDuration.InMilliseconds(), incTimeStat)},
+		{ratio, ""},
+	}
+	/* One column for each custom metric declared by the profile */
+	for i, m := range metrics {
+		value := report.MetricValueOrNone(f.Metrics, m.Name)
+		class := ""
+		if value != "" {
+			class = report.GetSeverityClass(f.Metrics[m.Name], metricStats[i])
+		}
+		row = append(row, cell{value, class})
+	}
+	return append(row, cell{f.FullName(), ""}, cell{location, ""})
+}
+
+func (r *TextReporter) writeRow(row []cell, widths []int) {
+	last := len(row) - 1
+	for i, c := range row {
+		var text string
+		if i >= last-1 {
+			/* Function name and location are left aligned */
+			text = fmt.Sprintf("%-*s", widths[i], c.text)
+			if i == last {
+				text = strings.TrimRight(text, " ")
+			}
+		} else {
+			text = fmt.Sprintf("%*s", widths[i], c.text)
+		}
+		if r.Color && c.class != "" {
+			text = severityColors[c.class] + text + colorReset
+		}
+		if i > 0 {
+			fmt.Fprint(r.w, "  ")
+		}
+		fmt.Fprint(r.w, text)
+	}
+	fmt.Fprintln(r.w)
+}
+
+func (r *TextReporter) ReportFunctions(p *json.Profile) {
+	functions := p.FileProfileMap.GetFunctionsSortedByExlusiveTime()
+	ownTimeStat, incTimeStat := report.GetMADStats(functions)
+	metricStats := report.GetFunctionMetricStats(functions, p.Metrics)
+
+	fth := report.FunctionTableHeaders
+	header := []cell{
+		{fth.Calls, ""}, {fth.Places, ""}, {fth.Files, ""},
+		{fth.SelfMs, ""}, {fth.InclusiveMs, ""}, {fth.Ratio, ""},
+	}
+	for i := range p.Metrics {
+		header = append(header, cell{p.Metrics[i].Title(), ""})
+	}
+	rows := [][]cell{append(header, cell{"Function", ""}, cell{"Location", ""})}
+	for _, f := range r.selectFunctions(functions) {
+		rows = append(rows, functionRow(f, ownTimeStat, incTimeStat, p.Metrics, metricStats))
+	}
+
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, c := range row {
+			if len(c.text) > widths[i] {
+				widths[i] = len(c.text)
+			}
+		}
+	}
+
+	fmt.Fprintf(r.w, "Duration: %sms, showing %d of %d functions sorted by %s\n\n",
+		p.Duration.InMillisecondsStr(), len(rows)-1, countFunctions(functions), r.SortBy)
+	for _, row := range rows {
+		r.writeRow(row, widths)
+	}
+}
+
+func countFunctions(functions json.FunctionProfileSlice) int {
+	n := 0
+	for _, f := range functions {
+		if f != nil {
+			n++
+		}
+	}
+	return n
+}
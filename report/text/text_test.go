@@ -0,0 +1,113 @@
+package text
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+import "fprof/json"
+
+var profileJson = []byte(`{
+	"duration": { "sec": 0, "nsec": 10000000 },
+	"files": {
+		"/a.fe": [
+			{
+				"functions": [
+					{
+						"name": "many",
+						"start_line": 1,
+						"exclusive_duration": { "sec": 0, "nsec": 0 },
+						"inclusive_duration": { "sec": 0, "nsec": 1000000 },
+						"hits": 100
+					},
+					{
+						"name": "slow",
+						"namespace": "Obj",
+						"start_line": 1,
+						"exclusive_duration": { "sec": 0, "nsec": 5000000 },
+						"inclusive_duration": { "sec": 0, "nsec": 8000000 },
+						"hits": 1
+					}
+				],
+				"hits": 1,
+				"total_duration": { "sec": 0, "nsec": 1000 }
+			}
+		]
+	}
+}`)
+
+func runReport(setup func(r *TextReporter)) []string {
+	var b bytes.Buffer
+	r := New(&b)
+	setup(r)
+	r.ReportFunctions(json.DecodeFromBytes(profileJson))
+	return strings.Split(strings.TrimRight(b.String(), "\n"), "\n")
+}
+
+func TestSortBy(t *testing.T) {
+	tests := []struct {
+		sortBy string
+		first  string
+	}{
+		{SortBySelf, "Obj.slow"},
+		{SortByInclusive, "Obj.slow"},
+		{SortByCalls, "many"},
+		{SortByRatio, "many"},
+	}
+	for _, tt := range tests {
+		lines := runReport(func(r *TextReporter) { r.SortBy = tt.sortBy })
+		if len(lines) != 5 {
+			t.Fatalf("Expected 5 lines, got %d: %q", len(lines), lines)
+		}
+		if !strings.Contains(lines[3], tt.first) {
+			t.Errorf("Sort by %s: expected %s first, got %q", tt.sortBy, tt.first, lines[3])
+		}
+	}
+}
+
+func TestAlignment(t *testing.T) {
+	lines := runReport(func(r *TextReporter) {})
+	header, row := lines[2], lines[4]
+	if strings.Index(header, "Function") != strings.Index(row, "many") {
+		t.Errorf("Function column is not aligned:\n%s\n%s", header, row)
+	}
+}
+
+func TestFilterAndColor(t *testing.T) {
+	lines := runReport(func(r *TextReporter) {
+		r.Filter = regexp.MustCompile(`^Obj\.`)
+		r.Top = 1
+		r.Color = true
+	})
+	if len(lines) != 4 || !strings.Contains(lines[3], "Obj.slow") {
+		t.Fatalf("Expected only Obj.slow, got %q", lines)
+	}
+	if !strings.Contains(lines[3], colorReset) {
+		t.Errorf("Expected ANSI colours in %q", lines[3])
+	}
+	if strings.Contains(lines[2], colorReset) {
+		t.Errorf("Header must not be coloured: %q", lines[2])
+	}
+}
+
+func TestMetricColumns(t *testing.T) {
+	var b bytes.Buffer
+	New(&b).ReportFunctions(json.DecodeFromBytes([]byte(`{
+		"metrics": [ { "name": "allocated", "unit": "bytes" } ],
+		"files": { "/a.fe": [ { "functions": [
+			{ "name": "f", "start_line": 1, "hits": 1, "metrics": { "allocated": 4096 } },
+			{ "name": "g", "start_line": 1, "hits": 1 }
+		] } ] }
+	}`)))
+	lines := strings.Split(strings.TrimRight(b.String(), "\n"), "\n")
+	if len(lines) != 5 || !strings.Contains(lines[2], "allocated (bytes)  Function") {
+		t.Fatalf("Expected an allocated column before Function, got %q", lines)
+	}
+	for _, line := range lines[3:] {
+		if strings.Contains(line, " f ") != strings.Contains(line, "4096") {
+			t.Errorf("allocated of the wrong function: %q", line)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package report
+
+import "fprof/json"
+
+/* What a thread of a multi-threaded program did */
+type ThreadSummary struct {
+	Id        string
+	Name      string
+	Duration  json.TimeSpec
+	Lines     int
+	Hits      json.Counter
+	Functions int
+	/* Time spent in the functions of the thread themselves */
+	Self json.TimeSpec
+	/* Share of Self in the Self of all threads, in percent */
+	Share float64
+}
+
+/* Summarizes the threads of a profile, in thread id order */
+func GetThreadSummaries(p *json.Profile) []ThreadSummary {
+	summaries := []ThreadSummary{}
+	var total int64
+	for _, id := range p.ThreadIds() {
+		t := p.Threads[id]
+		s := ThreadSummary{Id: id, Name: t.Name, Duration: t.Duration}
+		for _, lines := range t.FileProfileMap {
+			for _, lp := range lines {
+				if lp == nil {
+					continue
+				}
+				s.Lines++
+				s.Hits += lp.Hits
+				if lp.Functions == nil {
+					continue
+				}
+				for _, f := range *lp.Functions {
+					if f == nil {
+						continue
+					}
+					s.Functions++
+					self := f.InclusiveDuration
+					self.Subtract(f.ExclusiveDuration)
+					s.Self.Add(self)
+				}
+			}
+		}
+		total += s.Self.InNanoseconds()
+		summaries = append(summaries, s)
+	}
+	if total > 0 {
+		for i := range summaries {
+			summaries[i].Share = float64(summaries[i].Self.InNanoseconds()) * 100 / float64(total)
+		}
+	}
+	return summaries
+}
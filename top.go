@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"fprof/log"
+	"fprof/osutil"
+	"fprof/report/text"
+)
+
+func topCommand(args []string) {
+	fs := flag.NewFlagSet("top", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr,
+			"Usage: %s top [-n <count>] [-sort <key>] [-filter <regexp>] [-color auto|always|never] <file.json>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	var pTop = fs.Int("n", 20, "Number of functions to show, 0 for all")
+	var pSort = fs.String("sort", text.SortBySelf, "Sort by one of: "+strings.Join(text.SortKeys, ", "))
+	var pFilter = fs.String("filter", "", "Only show functions whose name matches the regular expression")
+	var pColor = fs.String("color", "auto", "Colour times by severity: auto, always or never")
+	var pVerbose = fs.Bool("v", false, "Be more verbose")
+	addInputFlags(fs)
+	fs.Parse(args)
+
+	initLogger(*pVerbose)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	r := text.New(w)
+	r.Top = *pTop
+	r.SortBy = *pSort
+	if *pFilter != "" {
+		re, err := regexp.Compile(*pFilter)
+		if err != nil {
+			log.Fatal(err)
+		}
+		r.Filter = re
+	}
+	switch *pColor {
+	case "auto":
+		r.Color = osutil.IsTerminal(os.Stdout)
+	case "always":
+		r.Color = true
+	case "never":
+		r.Color = false
+	default:
+		log.Fatal("Unknown -color value ", *pColor)
+	}
+
+	r.ReportFunctions(readProfile(fs.Arg(0)))
+}